@@ -0,0 +1,174 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The patterns ffs bench searches unless --patterns is given
+const defaultBenchPatterns = "*,github,*.pdf,*linkedin*,doesnotmatchanything"
+
+// The durations of the phases of searching one pattern in every run
+type benchTimes struct {
+	results int
+	query   []time.Duration
+	output  []time.Duration
+}
+
+// The flags of the bench subcommand
+var benchFlags struct {
+	patterns string
+	runs     int
+	sort     string
+	format   string
+	fts      bool
+}
+
+// Returns the flag set of the bench subcommand
+func benchFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	profileFlag(fs)
+	fs.StringVar(&benchFlags.patterns, "patterns", defaultBenchPatterns, "comma separated `patterns` to search")
+	fs.IntVar(&benchFlags.runs, "runs", 5, "how often every phase is timed, the median is printed")
+	fs.StringVar(&benchFlags.sort, "sort", "date", "sort order of the searches")
+	fs.StringVar(&benchFlags.format, "format", "csv", "output format timed: csv, json, rss, tsv or yaml")
+	fs.BoolVar(&benchFlags.fts, "fts", false, "search the full-text index, the patterns are full-text queries")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: ffs bench [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Runs the bench subcommand, which times taking a snapshot of the history,
+// opening it, searching it and writing the results, so ffs versions can be
+// compared on the same profile
+func runBench(args []string) {
+	if _, err := parseArgs(benchFlagSet(), args); err != nil {
+		os.Exit(exitError)
+	}
+
+	if benchFlags.runs < 1 {
+		fmt.Fprintf(os.Stderr, "runs must be positive\n")
+		os.Exit(exitError)
+	}
+	if _, ok := serveContentTypes[benchFlags.format]; !ok {
+		fmt.Fprintf(os.Stderr, "unknown format %q (available: csv, json, rss, tsv, yaml)\n", benchFlags.format)
+		os.Exit(exitError)
+	}
+	opts := defaultSearchOptions
+	opts.Sort = benchFlags.sort
+	opts.FullText = benchFlags.fts
+	if err := opts.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitError)
+	}
+
+	profileDir, err := getFirefoxProfileDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get Mozilla profile directory: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	open := openPlaces
+	if benchFlags.fts {
+		open = openIndex
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	queries := strings.Split(benchFlags.patterns, ",")
+	times := make([]benchTimes, len(queries))
+	var snapshot, opening []time.Duration
+	for range benchFlags.runs {
+		d, err := benchSnapshot(ctx, profileDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", contextError(ctx, err))
+			os.Exit(exitCode(exitError))
+		}
+		snapshot = append(snapshot, d)
+
+		start := time.Now()
+		db, cleanup, err := open(ctx, profileDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", contextError(ctx, err))
+			os.Exit(exitCode(exitError))
+		}
+		opening = append(opening, time.Since(start))
+
+		for i, query := range queries {
+			var results []*Result
+			start := time.Now()
+			err := searchHistory(ctx, db, query, opts, func(r *Result) error {
+				results = append(results, r)
+				return nil
+			})
+			if err != nil {
+				cleanup()
+				fmt.Fprintf(os.Stderr, "%s\n", contextError(ctx, err))
+				os.Exit(exitCode(exitError))
+			}
+			times[i].query = append(times[i].query, time.Since(start))
+			times[i].results = len(results)
+
+			start = time.Now()
+			out := newServeWriter(io.Discard, benchFlags.format, query)
+			for _, r := range results {
+				out.WriteResult(r)
+			}
+			out.Flush()
+			times[i].output = append(times[i].output, time.Since(start))
+		}
+		cleanup()
+	}
+
+	fmt.Printf("profile   %s\n", profileDir)
+	fmt.Printf("runs      %d\n", benchFlags.runs)
+	fmt.Printf("snapshot  %s\n", median(snapshot))
+	fmt.Printf("open      %s\n\n", median(opening))
+
+	width := len("pattern")
+	for _, query := range queries {
+		width = max(width, len(query))
+	}
+	fmt.Printf("%-*s  %8s  %10s  %10s  %10s\n", width, "pattern", "results", "query", "output", "total")
+	for i, query := range queries {
+		q, o := median(times[i].query), median(times[i].output)
+		fmt.Printf("%-*s  %8d  %10s  %10s  %10s\n", width, query, times[i].results, q, o, q+o)
+	}
+}
+
+// Returns how long taking a new copy of places.sqlite of profileDir takes,
+// the cached copy is left alone
+func benchSnapshot(ctx context.Context, profileDir string) (time.Duration, error) {
+	dir, err := makeSnapshotCacheDir(profileDir)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	tmp, err := copyDatabase(ctx, filepath.Join(profileDir, "places.sqlite"), dir, "places.sqlite")
+	if err != nil {
+		return 0, err
+	}
+	elapsed := time.Since(start)
+	os.RemoveAll(tmp)
+
+	return elapsed, nil
+}
+
+// Returns the median of durations, rounded to microseconds
+func median(durations []time.Duration) time.Duration {
+	sorted := slices.Sorted(slices.Values(durations))
+	return sorted[len(sorted)/2].Round(time.Microsecond)
+}
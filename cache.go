@@ -0,0 +1,321 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Returns the directory copies of the databases of profileDir are cached
+// in, $XDG_CACHE_HOME/ffs/<profile>-<hash of its path>
+func snapshotCacheDir(profileDir string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha1.Sum([]byte(profileDir))
+	key := filepath.Base(profileDir) + "-" + hex.EncodeToString(sum[:4])
+	return filepath.Join(cacheDir, "ffs", key), nil
+}
+
+// Creates the snapshotCacheDir of profileDir, if needed, and returns it
+func makeSnapshotCacheDir(profileDir string) (string, error) {
+	dir, err := snapshotCacheDir(profileDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to get cache directory: %s", err)
+	}
+
+	// The history is nobody elses business, also if the directory is older
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %s", err)
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %s", err)
+	}
+
+	return dir, nil
+}
+
+// How often a database that is written to while it is copied is copied again
+const copyAttempts = 3
+
+// Indexes built on the copies of the databases. Firefox looks pages up by
+// the hash of their URL, ffs by the URL itself, which would otherwise scan
+// the whole table for every page. The index on the visits of a page is
+// Firefox's own, in case the profile lacks it.
+var cacheIndexes = map[string][]string{
+	"places.sqlite": {
+		"CREATE INDEX IF NOT EXISTS ffs_places_url ON moz_places(url)",
+		"CREATE INDEX IF NOT EXISTS moz_historyvisits_placedateindex ON moz_historyvisits(place_id, visit_date)",
+	},
+}
+
+// Columns ffs reads that old or forked profiles lack, as table, column and
+// type. They are added to the copies of the databases, empty.
+var cacheColumns = map[string][][3]string{
+	"places.sqlite": {
+		{"moz_places", "description", "TEXT"},
+	},
+}
+
+// Returns the path of a copy of the database name in profileDir, which is
+// only copied again if it or its WAL changed since. Writes still in the WAL
+// are checkpointed into the copy. The copy is replaced atomically, so runs
+// reading the previous one are not affected. Copying stops when ctx is done.
+func cachedCopy(ctx context.Context, profileDir, name string) (string, error) {
+	src := filepath.Join(profileDir, name)
+	stamp, err := sourceStamp(src)
+	if err != nil {
+		return "", fmt.Errorf("could not open source file: %s", err)
+	}
+
+	dir, err := makeSnapshotCacheDir(profileDir)
+	if err != nil {
+		return "", err
+	}
+
+	dst := filepath.Join(dir, name)
+	if copyIsFresh(dst, stamp) {
+		return dst, nil
+	}
+
+	// Runs started together take turns copying, so the copy and its stamp
+	// stay in step and the later runs find the copy of the first
+	unlock, err := lockCacheDir(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	if stamp, err = sourceStamp(src); err != nil {
+		return "", fmt.Errorf("could not open source file: %s", err)
+	}
+	if copyIsFresh(dst, stamp) {
+		return dst, nil
+	}
+
+	var tmp string
+	for attempt := 1; ; attempt++ {
+		if tmp, err = copyDatabase(ctx, src, dir, name); err != nil {
+			return "", err
+		}
+
+		// A copy taken while Firefox wrote may mix old and new pages
+		after, err := sourceStamp(src)
+		if err != nil || after == stamp {
+			break
+		}
+		if attempt == copyAttempts {
+			fmt.Fprintf(os.Stderr, "Firefox kept writing to %s while it was copied, results may lag the running session\n", name)
+			break
+		}
+		os.RemoveAll(tmp)
+		stamp = after
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := os.Rename(filepath.Join(tmp, name), dst); err != nil {
+		return "", fmt.Errorf("could not copy file: %s", err)
+	}
+	if err := os.WriteFile(dst+".stamp", []byte(stamp), 0600); err != nil {
+		return "", fmt.Errorf("could not copy file: %s", err)
+	}
+
+	return dst, nil
+}
+
+// Reports whether the copy at dst was taken of the source with stamp
+func copyIsFresh(dst, stamp string) bool {
+	cached, err := os.ReadFile(dst + ".stamp")
+	if err != nil || string(cached) != stamp {
+		return false
+	}
+	_, err = os.Stat(dst)
+
+	return err == nil
+}
+
+// Takes the lock of the cache directory dir, which is held while copies
+// are taken, waiting until ctx is done. The returned func releases it.
+func lockCacheDir(ctx context.Context, dir string) (func(), error) {
+	fh, err := os.OpenFile(filepath.Join(dir, "lock"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not lock cache directory: %s", err)
+	}
+
+	for {
+		err := syscall.Flock(int(fh.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			// Closing the file releases the lock
+			return func() { fh.Close() }, nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			fh.Close()
+			return nil, fmt.Errorf("could not lock cache directory: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			fh.Close()
+			return nil, ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// Returns the size and modification time of the database at path and its
+// WAL, which change whenever it is written to
+func sourceStamp(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	stamp := fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano())
+	if wal, err := os.Stat(path + "-wal"); err == nil {
+		stamp += fmt.Sprintf(" %d %d", wal.Size(), wal.ModTime().UnixNano())
+	}
+
+	return stamp, nil
+}
+
+// Copies the database at src and its WAL, if any, to a new temporary
+// directory in dir as name, checkpoints the WAL into it and builds the
+// cacheIndexes of name. A corrupt database is salvaged. Returns the
+// temporary directory.
+func copyDatabase(ctx context.Context, src, dir, name string) (string, error) {
+	tmp, err := os.MkdirTemp(dir, name+".*")
+	if err != nil {
+		return "", fmt.Errorf("could not create destination file: %s", err)
+	}
+
+	dst := filepath.Join(tmp, name)
+	err = copyFile(src, dst)
+	if _, walErr := os.Stat(src + "-wal"); err == nil && walErr == nil {
+		err = copyFile(src+"-wal", dst+"-wal")
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		// The temporary directory is gone, name where the copy is cached
+		var noSpace *noSpaceError
+		if errors.As(err, &noSpace) {
+			noSpace.dir = dir
+		}
+		return "", err
+	}
+
+	if err := ctx.Err(); err != nil {
+		os.RemoveAll(tmp)
+		return "", err
+	}
+
+	db, err := sql.Open(driverName, dst)
+	if err == nil {
+		err = prepareCopy(ctx, db, name)
+		db.Close()
+	}
+	// Building the indexes reads every page and visit
+	if isCorrupt(err) {
+		err = salvageCopy(ctx, dst, name)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return "", fmt.Errorf("failed to prepare copy: %s", err)
+	}
+
+	return tmp, nil
+}
+
+// Checkpoints the WAL of a copy of the database name, adds the columns it
+// lacks and creates indexes on it
+func prepareCopy(ctx context.Context, db *sql.DB, name string) error {
+	// Leaving WAL mode checkpoints and removes the WAL, so the copy can be
+	// read on its own
+	if _, err := db.ExecContext(ctx, "PRAGMA journal_mode=DELETE"); err != nil {
+		return err
+	}
+
+	for _, column := range cacheColumns[name] {
+		ok, err := hasColumn(ctx, db, column[0], column[1])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if _, err := db.ExecContext(ctx, "ALTER TABLE "+column[0]+" ADD COLUMN "+column[1]+" "+column[2]); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, index := range cacheIndexes[name] {
+		if _, err := db.ExecContext(ctx, index); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reports whether table in db has column
+func hasColumn(ctx context.Context, db *sql.DB, table, column string) (bool, error) {
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&n)
+	return n > 0, err
+}
+
+// The error of a copy that does not fit into the free space left
+type noSpaceError struct {
+	name, dir   string
+	need, avail uint64
+}
+
+func (e *noSpaceError) Error() string {
+	return fmt.Sprintf("not enough space to copy %s: %s needed, %s free in %s",
+		e.name, formatBytes(e.need), formatBytes(e.avail), e.dir)
+}
+
+// Returns a *noSpaceError if the file system of dst has less space left
+// than src takes
+func checkSpace(dst, src *os.File) error {
+	info, err := src.Stat()
+	if err != nil {
+		return nil
+	}
+
+	var fs syscall.Statfs_t
+	if err := syscall.Fstatfs(int(dst.Fd()), &fs); err != nil {
+		return nil
+	}
+
+	need, avail := uint64(info.Size()), fs.Bavail*uint64(fs.Bsize)
+	if need > avail {
+		return &noSpaceError{name: filepath.Base(dst.Name()), dir: filepath.Dir(dst.Name()), need: need, avail: avail}
+	}
+
+	return nil
+}
+
+// Returns n bytes in KiB, MiB or GiB as appropriate
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	value, prefix := float64(n)/unit, 0
+	for value >= unit && prefix < 2 {
+		value /= unit
+		prefix++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", value, "KMG"[prefix])
+}
@@ -0,0 +1,147 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// CityHash64 v1.0 as bundled with Firefox, which names the [Install]
+// sections of profiles.ini after the hash of the installation directory
+
+const (
+	cityK0 = 0xc3a5c85c97cb3127
+	cityK1 = 0xb492b66fbe98f273
+	cityK2 = 0x9ae16a3b2f90404f
+	cityK3 = 0xc949d7c7509e6557
+)
+
+// Returns the CityHash64 of s
+func cityHash64(s []byte) uint64 {
+	n := uint64(len(s))
+	switch {
+	case n <= 16:
+		return cityHashLen0to16(s)
+	case n <= 32:
+		return cityHashLen17to32(s)
+	case n <= 64:
+		return cityHashLen33to64(s)
+	}
+
+	// The end is hashed first, then 64 byte chunks
+	x := fetch64(s)
+	y := fetch64(s[n-16:]) ^ cityK1
+	z := fetch64(s[n-56:]) ^ cityK0
+	v1, v2 := weakHashLen32WithSeeds(s[n-64:], n, y)
+	w1, w2 := weakHashLen32WithSeeds(s[n-32:], n*cityK1, cityK0)
+	z += shiftMix(v2) * cityK1
+	x = rotate(z+x, 39) * cityK1
+	y = rotate(y, 33) * cityK1
+
+	for n = (n - 1) &^ 63; n != 0; n -= 64 {
+		x = rotate(x+y+v1+fetch64(s[16:]), 37) * cityK1
+		y = rotate(y+v2+fetch64(s[48:]), 42) * cityK1
+		x ^= w2
+		y ^= v1
+		z = rotate(z^w1, 33)
+		v1, v2 = weakHashLen32WithSeeds(s, v2*cityK1, x+w1)
+		w1, w2 = weakHashLen32WithSeeds(s[32:], z+w2, y)
+		z, x = x, z
+		s = s[64:]
+	}
+
+	return hashLen16(hashLen16(v1, w1)+shiftMix(y)*cityK1+z, hashLen16(v2, w2)+x)
+}
+
+func cityHashLen0to16(s []byte) uint64 {
+	n := uint64(len(s))
+	switch {
+	case n > 8:
+		a, b := fetch64(s), fetch64(s[n-8:])
+		return hashLen16(a, rotate(b+n, int(n))) ^ b
+	case n >= 4:
+		a := fetch32(s)
+		return hashLen16(n+(a<<3), fetch32(s[n-4:]))
+	case n > 0:
+		a, b, c := uint64(s[0]), uint64(s[n>>1]), uint64(s[n-1])
+		y := a + (b << 8)
+		z := n + (c << 2)
+		return shiftMix(y*cityK2^z*cityK3) * cityK2
+	}
+
+	return cityK2
+}
+
+func cityHashLen17to32(s []byte) uint64 {
+	n := uint64(len(s))
+	a := fetch64(s) * cityK1
+	b := fetch64(s[8:])
+	c := fetch64(s[n-8:]) * cityK2
+	d := fetch64(s[n-16:]) * cityK0
+
+	return hashLen16(rotate(a-b, 43)+rotate(c, 30)+d, a+rotate(b^cityK3, 20)-c+n)
+}
+
+func cityHashLen33to64(s []byte) uint64 {
+	n := uint64(len(s))
+	z := fetch64(s[24:])
+	a := fetch64(s) + (n+fetch64(s[n-16:]))*cityK0
+	b := rotate(a+z, 52)
+	c := rotate(a, 37)
+	a += fetch64(s[8:])
+	c += rotate(a, 7)
+	a += fetch64(s[16:])
+	vf := a + z
+	vs := b + rotate(a, 31) + c
+
+	a = fetch64(s[16:]) + fetch64(s[n-32:])
+	z = fetch64(s[n-8:])
+	b = rotate(a+z, 52)
+	c = rotate(a, 37)
+	a += fetch64(s[n-24:])
+	c += rotate(a, 7)
+	a += fetch64(s[n-16:])
+	wf := a + z
+	ws := b + rotate(a, 31) + c
+
+	r := shiftMix((vf+ws)*cityK2 + (wf+vs)*cityK0)
+	return shiftMix(r*cityK0+vs) * cityK2
+}
+
+func weakHashLen32WithSeeds(s []byte, a, b uint64) (uint64, uint64) {
+	w, x, y, z := fetch64(s), fetch64(s[8:]), fetch64(s[16:]), fetch64(s[24:])
+	a += w
+	b = rotate(b+a+z, 21)
+	c := a
+	a += x + y
+	b += rotate(a, 44)
+
+	return a + z, b + c
+}
+
+func hashLen16(u, v uint64) uint64 {
+	const mul = 0x9ddfea08eb382d69
+	a := (u ^ v) * mul
+	a ^= a >> 47
+	b := (v ^ a) * mul
+	b ^= b >> 47
+
+	return b * mul
+}
+
+func fetch64(s []byte) uint64 {
+	return binary.LittleEndian.Uint64(s)
+}
+
+func fetch32(s []byte) uint64 {
+	return uint64(binary.LittleEndian.Uint32(s))
+}
+
+func rotate(v uint64, shift int) uint64 {
+	return bits.RotateLeft64(v, -shift)
+}
+
+func shiftMix(v uint64) uint64 {
+	return v ^ (v >> 47)
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Clipboard tools and their arguments to read the text from stdin,
+// in order of preference
+var clipboardTools = [][]string{
+	{"wl-copy"},
+	{"xclip", "-selection", "clipboard"},
+	{"xsel", "--clipboard", "--input"},
+}
+
+// Puts text on the clipboard using the first clipboard tool found
+func copyToClipboard(text string) error {
+	for _, tool := range clipboardTools {
+		// wl-copy only works in a Wayland session, the others need X
+		// (or XWayland)
+		if tool[0] == "wl-copy" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			continue
+		}
+		if tool[0] != "wl-copy" && os.Getenv("DISPLAY") == "" {
+			continue
+		}
+		if _, err := exec.LookPath(tool[0]); err != nil {
+			continue
+		}
+
+		// Not capturing the output, the tools stay in the background to
+		// serve the clipboard and would keep the pipes open
+		cmd := exec.Command(tool[0], tool[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s failed: %s", tool[0], err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("no clipboard tool found for this session (wl-copy on Wayland, xclip or xsel on X)")
+}
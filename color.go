@@ -0,0 +1,131 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	colorMatch = "\x1b[1;31m"
+	colorReset = "\x1b[0m"
+)
+
+// Highlights the parts of strings matching a glob pattern
+type highlighter struct {
+	re *regexp.Regexp
+}
+
+// Creates a highlighter for the literal parts of a glob pattern,
+// returns nil if there is nothing worth highlighting
+func newHighlighter(pattern string) *highlighter {
+	re := matchRegexp(pattern)
+	if re == nil {
+		return nil
+	}
+
+	return &highlighter{re: re}
+}
+
+// Returns a case-insensitive regular expression finding the part of a
+// string a query matches, nil if the query matches everything
+func matchRegexp(query string) *regexp.Regexp {
+	// Leading and trailing wildcards would match everything
+	pattern := strings.Trim(convertToGlobPattern(query), "*")
+	if pattern == "" {
+		return nil
+	}
+
+	re, err := regexp.Compile("(?i)" + globToRegexp(pattern))
+	if err != nil {
+		return nil
+	}
+
+	return re
+}
+
+// Wraps all matches in s in ANSI colors
+func (h *highlighter) Highlight(s string) string {
+	if h == nil {
+		return s
+	}
+
+	return h.re.ReplaceAllStringFunc(s, func(m string) string {
+		return colorMatch + m + colorReset
+	})
+}
+
+// Translates a SQLite GLOB pattern into an unanchored regular expression
+func globToRegexp(pattern string) string {
+	var sb strings.Builder
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '*':
+			sb.WriteString(".*?")
+		case '?':
+			sb.WriteString(".")
+		case '[':
+			// Find the end of the class, a leading ] (after an optional ^) is literal
+			j := i + 1
+			if j < len(runes) && runes[j] == '^' {
+				j++
+			}
+			if j < len(runes) && runes[j] == ']' {
+				j++
+			}
+			for j < len(runes) && runes[j] != ']' {
+				j++
+			}
+			if j >= len(runes) {
+				sb.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+
+			class := runes[i+1 : j]
+			negate := len(class) > 0 && class[0] == '^'
+			if negate {
+				class = class[1:]
+			}
+
+			sb.WriteString("[")
+			if negate {
+				sb.WriteString("^")
+			}
+			for _, r := range class {
+				if r == '-' {
+					sb.WriteRune(r)
+				} else {
+					sb.WriteString(regexp.QuoteMeta(string(r)))
+				}
+			}
+			sb.WriteString("]")
+			i = j
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return sb.String()
+}
+
+// Decides whether to use colors for the --color mode, auto enables them
+// if the output is interactive
+func useColor(mode string, interactive bool) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+			return false, nil
+		}
+		return interactive, nil
+	default:
+		return false, fmt.Errorf("unknown color mode %q (available: auto, always, never)", mode)
+	}
+}
@@ -0,0 +1,379 @@
+//go:build linux
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Shells ffs completion and ffs widget have code for
+var completionShells = []string{"bash", "fish", "zsh"}
+
+// How the value of a flag is completed
+const (
+	completeNone = iota
+	completeFile
+	completeCommand
+	// The values printed by ffs completion --values
+	completeValues
+)
+
+// A flag as completed by the shells
+type completionFlag struct {
+	// The flag with its dashes, -o or --output
+	name  string
+	usage string
+	// The name of its value, "" for boolean flags
+	arg  string
+	kind int
+}
+
+// Runs the completion subcommand, printing the completion script of a shell
+// or, with --values, the values of a flag the scripts complete
+func runCompletion(args []string) {
+	if len(args) == 3 && args[0] == "--values" {
+		for _, v := range flagValues(args[1], args[2]) {
+			fmt.Println(v)
+		}
+		return
+	}
+
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: ffs completion %s\n", strings.Join(completionShells, "|"))
+		os.Exit(exitError)
+	}
+
+	var script string
+	switch args[0] {
+	case "bash":
+		script = bashCompletion()
+	case "fish":
+		script = fishCompletion()
+	case "zsh":
+		script = zshCompletion()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown shell %q (available: %s)\n", args[0], strings.Join(completionShells, ", "))
+		os.Exit(exitError)
+	}
+
+	fmt.Print(script)
+}
+
+// Returns the flags of cmd
+func subcommandFlags(cmd subcommand) *flag.FlagSet {
+	if cmd.flags == nil {
+		return flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	}
+	return cmd.flags()
+}
+
+// Returns the flags of fs in order, cmd is the subcommand or "ffs"
+func completionFlags(cmd string, fs *flag.FlagSet) []completionFlag {
+	var flags []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		arg, usage := flag.UnquoteUsage(f)
+		c := completionFlag{name: "--" + f.Name, usage: usage, arg: arg}
+		if len(f.Name) == 1 {
+			c.name = "-" + f.Name
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			c.arg = ""
+		} else {
+			c.kind = valueCompletion(cmd, f.Name)
+		}
+		flags = append(flags, c)
+	})
+
+	return flags
+}
+
+// Returns how the value of the flag name of cmd is completed
+func valueCompletion(cmd, name string) int {
+	switch name {
+	case "output", "o", "export-sqlite":
+		return completeFile
+	case "browser", "menu-command":
+		return completeCommand
+	}
+	if flagValues(cmd, name) != nil || name == "install" || name == "profile" {
+		return completeValues
+	}
+
+	return completeNone
+}
+
+// Returns the values the flag name of cmd is completed with, nil if any
+// value goes. Those of --install and --profile depend on profiles.ini.
+func flagValues(cmd, name string) []string {
+	for _, sub := range subcommands {
+		if sub.name == cmd && sub.flags != nil && sub.flags() == flag.CommandLine {
+			cmd = "ffs"
+		}
+	}
+
+	switch {
+	case name == "sort":
+		return slices.Sorted(maps.Keys(sortOrders))
+	case cmd == "bench" && name == "format":
+		return slices.Sorted(maps.Keys(serveContentTypes))
+	case cmd == "export" && name == "format":
+		return []string{"netscape"}
+	case name == "install":
+		ffdir, err := firefoxDir()
+		if err != nil {
+			return nil
+		}
+		installs, _ := listInstalls(ffdir)
+		values := make([]string, len(installs))
+		for i, in := range installs {
+			values[i] = in.Hash
+		}
+		return values
+	case name == "profile":
+		ffdir, err := firefoxDir()
+		if err != nil {
+			return nil
+		}
+		profiles, _ := listProfiles(ffdir)
+		values := make([]string, len(profiles))
+		for i, p := range profiles {
+			values[i] = p.Name
+		}
+		return values
+	case cmd != "ffs":
+		return nil
+	}
+
+	switch name {
+	case "color":
+		return []string{"auto", "always", "never"}
+	case "date-format":
+		return []string{"rfc3339", "relative"}
+	case "markdown-style":
+		return []string{"list", "table"}
+	case "compress":
+		return []string{"gzip", "zstd"}
+	case "group-by":
+		return []string{"domain"}
+	case "launcher":
+		return []string{"json"}
+	case "columns":
+		return allColumns
+	case "pragma":
+		values := make([]string, len(tunablePragmas))
+		for i, p := range tunablePragmas {
+			values[i] = p + "="
+		}
+		return values
+	}
+
+	return nil
+}
+
+// Returns the bash completion script
+func bashCompletion() string {
+	var sb strings.Builder
+	sb.WriteString("# bash completion for ffs, generated by ffs completion bash\n")
+	sb.WriteString("_ffs() {\n")
+	sb.WriteString("  local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]} cmd=ffs\n")
+	sb.WriteString("  if (( COMP_CWORD > 1 )); then\n    case ${COMP_WORDS[1]} in\n")
+	fmt.Fprintf(&sb, "      %s) cmd=${COMP_WORDS[1]} ;;\n", strings.Join(subcommandNames(), "|"))
+	sb.WriteString("    esac\n  fi\n\n")
+
+	sb.WriteString("  local flags files commands values args positional\n  case $cmd in\n")
+	writeCase := func(cmd string, flags []completionFlag, positional []string) {
+		kinds := make(map[int][]string)
+		var names, args []string
+		for _, f := range flags {
+			names = append(names, f.name)
+			if f.arg != "" {
+				args = append(args, f.name)
+				kinds[f.kind] = append(kinds[f.kind], f.name)
+			}
+		}
+		fmt.Fprintf(&sb, "    %s)\n", cmd)
+		fmt.Fprintf(&sb, "      flags=%q\n", strings.Join(names, " "))
+		fmt.Fprintf(&sb, "      args=%q files=%q commands=%q values=%q\n", strings.Join(args, " "), strings.Join(kinds[completeFile], " "), strings.Join(kinds[completeCommand], " "), strings.Join(kinds[completeValues], " "))
+		fmt.Fprintf(&sb, "      positional=%q\n      ;;\n", strings.Join(positional, " "))
+	}
+	for _, cmd := range subcommands {
+		writeCase(cmd.name, completionFlags(cmd.name, subcommandFlags(cmd)), cmd.args)
+	}
+	writeCase("*", completionFlags("ffs", flag.CommandLine), nil)
+	sb.WriteString("  esac\n\n")
+
+	sb.WriteString(`  # Go flags take one or two dashes
+  local name=${prev##-}
+  name=${name#-}
+  local opt=--$name
+  (( ${#name} == 1 )) && opt=-$name
+  if [[ $prev == -* && " $args " == *" $opt "* ]]; then
+    if [[ " $files " == *" $opt "* ]]; then
+      compopt -o filenames
+      COMPREPLY=($(compgen -f -- "$cur"))
+    elif [[ " $commands " == *" $opt "* ]]; then
+      COMPREPLY=($(compgen -c -- "$cur"))
+    elif [[ " $values " == *" $opt "* ]]; then
+      local IFS=$'\n'
+      COMPREPLY=($(compgen -W "$(ffs completion --values "$cmd" "$name" 2>/dev/null)" -- "$cur"))
+    fi
+    return
+  fi
+
+  if [[ $cur == -* ]]; then
+    COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+  elif (( COMP_CWORD == 1 )); then
+`)
+	fmt.Fprintf(&sb, "    COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(subcommandNames(), " "))
+	sb.WriteString(`  elif [[ -n $positional ]]; then
+    COMPREPLY=($(compgen -W "$positional" -- "$cur"))
+  fi
+}
+complete -F _ffs ffs
+`)
+
+	return sb.String()
+}
+
+// Returns the zsh completion script
+func zshCompletion() string {
+	quote := func(s string) string {
+		return strings.ReplaceAll(s, "'", `'\''`)
+	}
+	// Brackets end the description in the specs of _arguments, colons the
+	// other parts
+	escape := strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`)
+	escapeArg := strings.NewReplacer(`\`, `\\`, ":", `\:`)
+
+	specs := func(cmd string, flags []completionFlag) string {
+		var sb strings.Builder
+		for _, f := range flags {
+			if f.arg == "" {
+				fmt.Fprintf(&sb, "    '%s[%s]' \\\n", f.name, quote(escape.Replace(f.usage)))
+				continue
+			}
+
+			action := " "
+			switch f.kind {
+			case completeFile:
+				action = "_files"
+			case completeCommand:
+				action = "_command_names -e"
+			case completeValues:
+				action = fmt.Sprintf("{_ffs_values %s %s}", cmd, strings.TrimLeft(f.name, "-"))
+			}
+			name := f.name
+			if strings.HasPrefix(name, "--") {
+				name += "="
+			}
+			fmt.Fprintf(&sb, "    '%s[%s]:%s:%s' \\\n", name, quote(escape.Replace(f.usage)), quote(escapeArg.Replace(f.arg)), action)
+		}
+		return sb.String()
+	}
+
+	var sb strings.Builder
+	sb.WriteString("#compdef ffs\n# zsh completion for ffs, generated by ffs completion zsh\n\n")
+	sb.WriteString(`_ffs_values() {
+  local -a values
+  values=(${(f)"$(ffs completion --values "$1" "$2" 2>/dev/null)"})
+  compadd -a values
+}
+
+_ffs() {
+  local -a subcommands
+  subcommands=(
+`)
+	for _, cmd := range subcommands {
+		fmt.Fprintf(&sb, "    '%s:%s'\n", cmd.name, quote(strings.ReplaceAll(cmd.description, ":", `\:`)))
+	}
+	sb.WriteString(`  )
+
+  if (( CURRENT == 2 )) && [[ $words[2] != -* ]]; then
+    _describe -t subcommands subcommand subcommands
+    return
+  fi
+
+  case $words[2] in
+`)
+	for _, cmd := range subcommands {
+		fmt.Fprintf(&sb, "  %s)\n    shift words\n    (( CURRENT-- ))\n    _arguments -S \\\n", cmd.name)
+		sb.WriteString(specs(cmd.name, completionFlags(cmd.name, subcommandFlags(cmd))))
+		if len(cmd.args) > 0 {
+			fmt.Fprintf(&sb, "    '1:%s:(%s)'\n    ;;\n", cmd.name, strings.Join(cmd.args, " "))
+		} else {
+			sb.WriteString("    '*:argument: '\n    ;;\n")
+		}
+	}
+	sb.WriteString("  *)\n    _arguments -S \\\n")
+	sb.WriteString(specs("ffs", completionFlags("ffs", flag.CommandLine)))
+	sb.WriteString("    '*:query: '\n    ;;\n  esac\n}\n\n_ffs \"$@\"\n")
+
+	return sb.String()
+}
+
+// Returns the fish completion script
+func fishCompletion() string {
+	quote := func(s string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+	}
+	names := strings.Join(subcommandNames(), " ")
+
+	var sb strings.Builder
+	sb.WriteString("# fish completion for ffs, generated by ffs completion fish\n")
+	sb.WriteString("complete -c ffs -f\n\n")
+	for _, cmd := range subcommands {
+		fmt.Fprintf(&sb, "complete -c ffs -n __fish_use_subcommand -a %s -d %s\n", cmd.name, quote(cmd.description))
+	}
+
+	writeFlags := func(cmd, condition string, flags []completionFlag) {
+		if len(flags) > 0 {
+			sb.WriteString("\n")
+		}
+		for _, f := range flags {
+			opt := "-l " + strings.TrimPrefix(f.name, "--")
+			if !strings.HasPrefix(f.name, "--") {
+				opt = "-s " + strings.TrimPrefix(f.name, "-")
+			}
+			fmt.Fprintf(&sb, "complete -c ffs -n %s %s -d %s", quote(condition), opt, quote(f.usage))
+			if f.arg != "" {
+				switch f.kind {
+				case completeFile:
+					sb.WriteString(" -r -F")
+				case completeCommand:
+					sb.WriteString(" -x -a '(__fish_complete_command)'")
+				case completeValues:
+					fmt.Fprintf(&sb, " -x -a '(ffs completion --values %s %s)'", cmd, strings.TrimLeft(f.name, "-"))
+				default:
+					sb.WriteString(" -x")
+				}
+			}
+			sb.WriteString("\n")
+		}
+	}
+
+	writeFlags("ffs", "not __fish_seen_subcommand_from "+names, completionFlags("ffs", flag.CommandLine))
+	for _, cmd := range subcommands {
+		condition := "__fish_seen_subcommand_from " + cmd.name
+		writeFlags(cmd.name, condition, completionFlags(cmd.name, subcommandFlags(cmd)))
+		if len(cmd.args) > 0 {
+			fmt.Fprintf(&sb, "complete -c ffs -n %s -a %s\n", quote(condition), quote(strings.Join(cmd.args, " ")))
+		}
+	}
+
+	return sb.String()
+}
+
+// Returns the names of the subcommands
+func subcommandNames() []string {
+	names := make([]string, len(subcommands))
+	for i, cmd := range subcommands {
+		names[i] = cmd.name
+	}
+
+	return names
+}
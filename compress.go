@@ -0,0 +1,34 @@
+//go:build linux
+
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Wraps w in a streaming compressor for method, "" disables compression.
+// Closing the returned writer flushes the compressor but not w.
+func compressWriter(w io.Writer, method string) (io.WriteCloser, error) {
+	switch method {
+	case "":
+		return nopWriteCloser{w}, nil
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unknown compression %q (available: gzip, zstd)", method)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
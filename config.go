@@ -0,0 +1,316 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A setting of config.toml, the value of a flag
+type configEntry struct {
+	key string
+	// Every value is set in turn, arrays set repeatable flags more than once
+	values []string
+	line   int
+}
+
+// The settings of config.toml by section, "" for those before the first
+// section, which apply to every command with the flag, or else the name of
+// the subcommand they apply to
+type config struct {
+	path     string
+	sections map[string][]configEntry
+}
+
+// Where a flag was set, in order of precedence
+type flagSource int
+
+const (
+	sourceConfig flagSource = iota + 1
+	sourceEnv
+	sourceArgs
+)
+
+// The flags of ffs itself that were set by config.toml or the environment,
+// not on the command line
+var flagSources = make(map[string]flagSource)
+
+// Returns the path of config.toml
+func configPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("could not get config directory: %s", err)
+	}
+
+	return filepath.Join(configDir, "ffs", "config.toml"), nil
+}
+
+// Reads config.toml once, an empty config if there is none
+var loadConfig = sync.OnceValues(func() (*config, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+
+	fh, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &config{path: path}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open config: %s", err)
+	}
+	defer fh.Close()
+
+	return parseConfig(path, fh)
+})
+
+// Parses the subset of TOML config.toml is written in: sections, keys
+// with strings, numbers or booleans, and arrays of them
+func parseConfig(path string, r io.Reader) (*config, error) {
+	cfg := &config{path: path, sections: make(map[string][]configEntry)}
+
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			name, rest, ok := strings.Cut(line[1:], "]")
+			rest = strings.TrimSpace(rest)
+			if !ok || strings.HasPrefix(name, "[") || (rest != "" && !strings.HasPrefix(rest, "#")) {
+				return nil, fmt.Errorf("%s:%d: invalid section %s", path, n, line)
+			}
+			section = strings.TrimSpace(name)
+			if !slices.ContainsFunc(subcommands, func(cmd subcommand) bool {
+				return cmd.name == section && cmd.flags != nil
+			}) {
+				return nil, fmt.Errorf("%s:%d: unknown section %s", path, n, line)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		values, err := parseConfigValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, n, err)
+		}
+
+		cfg.sections[section] = append(cfg.sections[section], configEntry{key, values, n})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading config: %s", err)
+	}
+
+	return cfg, nil
+}
+
+// Parses a TOML value, returns the values of an array or else the value
+func parseConfigValue(s string) ([]string, error) {
+	if !strings.HasPrefix(s, "[") {
+		value, rest, err := parseConfigScalar(s)
+		if err != nil {
+			return nil, err
+		}
+		if rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, fmt.Errorf("unexpected %q after value", rest)
+		}
+		return []string{value}, nil
+	}
+
+	var values []string
+	s = strings.TrimSpace(s[1:])
+	for !strings.HasPrefix(s, "]") {
+		value, rest, err := parseConfigScalar(s)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		if strings.HasPrefix(rest, ",") {
+			rest = strings.TrimSpace(rest[1:])
+		} else if !strings.HasPrefix(rest, "]") {
+			return nil, fmt.Errorf("arrays must be on one line and separated by commas")
+		}
+		s = rest
+	}
+
+	if rest := strings.TrimSpace(s[1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+		return nil, fmt.Errorf("unexpected %q after value", rest)
+	}
+
+	return values, nil
+}
+
+// Parses the string, number or boolean at the start of s, returns it and
+// what follows
+func parseConfigScalar(s string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		// Basic strings escape like Go strings do
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				value, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", "", fmt.Errorf("invalid string %s", s[:i+1])
+				}
+				return value, strings.TrimSpace(s[i+1:]), nil
+			}
+		}
+		return "", "", fmt.Errorf("string is never closed")
+	case strings.HasPrefix(s, "'"):
+		value, rest, ok := strings.Cut(s[1:], "'")
+		if !ok {
+			return "", "", fmt.Errorf("string is never closed")
+		}
+		return value, strings.TrimSpace(rest), nil
+	}
+
+	end := strings.IndexAny(s, " \t,]#")
+	if end < 0 {
+		end = len(s)
+	}
+	value := s[:end]
+	if value == "" {
+		return "", "", fmt.Errorf("missing value")
+	}
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(value, "_", ""), 64); err != nil && value != "true" && value != "false" {
+		return "", "", fmt.Errorf("invalid value %s, strings need quotes", value)
+	}
+
+	return strings.ReplaceAll(value, "_", ""), strings.TrimSpace(s[end:]), nil
+}
+
+// Returns the environment variable of the flag name, e.g. FFS_NO_DAEMON
+// for --no-daemon
+func flagEnv(name string) string {
+	return "FFS_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Sets the flags of fs that were not given on the command line to their
+// FFS_* environment variable, or else to their values in config.toml.
+// Settings before the first section are flags of ffs itself and also apply
+// to the subcommands with the flag, those of a section named like a
+// subcommand only to it. ffs without a command reads [search] too.
+func applyConfig(fs *flag.FlagSet) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	addAliases(fs, given)
+
+	root := fs == flag.CommandLine
+	if err := applyEnv(fs, given); err != nil {
+		return err
+	}
+	// Those of the flags set by the environment
+	addAliases(fs, given)
+	entries := slices.Concat(cfg.sections[""], cfg.sections[fs.Name()])
+	// The settings of the section come after the shared ones
+	shared := len(cfg.sections[""])
+
+	for i, e := range entries {
+		if fs.Lookup(e.key) == nil {
+			// Shared settings may be meant for other commands
+			if !root && i < shared {
+				continue
+			}
+			return fmt.Errorf("%s:%d: unknown flag %s", cfg.path, e.line, e.key)
+		}
+		if given[e.key] {
+			continue
+		}
+
+		for _, value := range e.values {
+			if err := fs.Set(e.key, value); err != nil {
+				return fmt.Errorf("%s:%d: invalid value %q for %s: %s", cfg.path, e.line, value, e.key, err)
+			}
+		}
+		if root {
+			flagSources[e.key] = sourceConfig
+		}
+	}
+
+	return nil
+}
+
+// Adds the flags of fs that share their value with a flag in given, e.g.
+// --output for -o, so a shorthand takes precedence like its long flag
+func addAliases(fs *flag.FlagSet, given map[string]bool) {
+	// Values of flag.Func are funcs, which cannot be compared
+	values := make(map[flag.Value]bool)
+	fs.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] && reflect.TypeOf(f.Value).Comparable() {
+			values[f.Value] = true
+		}
+	})
+	fs.VisitAll(func(f *flag.Flag) {
+		if reflect.TypeOf(f.Value).Comparable() && values[f.Value] {
+			given[f.Name] = true
+		}
+	})
+}
+
+// Sets the flags of fs that were not given on the command line and have an
+// FFS_* environment variable to its value, and adds them to given. Shorthand
+// flags have none. FFS_FORMAT also takes the name of an output format
+// flag, e.g. csv, otherwise it is the template of --format.
+func applyEnv(fs *flag.FlagSet, given map[string]bool) error {
+	root := fs == flag.CommandLine
+	set := func(name, env, value string) error {
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %s", value, env, err)
+		}
+		given[name] = true
+		if root {
+			flagSources[name] = sourceEnv
+		}
+		return nil
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		env := flagEnv(f.Name)
+		value, ok := os.LookupEnv(env)
+		if err != nil || !ok || given[f.Name] || len(f.Name) == 1 {
+			return
+		}
+
+		if f.Name == "format" && root && slices.Contains(formatFlags, value) {
+			if !given[value] {
+				err = set(value, env, "true")
+			}
+			return
+		}
+		err = set(f.Name, env, value)
+	})
+
+	return err
+}
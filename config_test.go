@@ -0,0 +1,75 @@
+//go:build linux
+
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+// Replaces config.toml with text for the rest of the test
+func setConfig(t *testing.T, text string) {
+	cfg, err := parseConfig("config.toml", strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orig := loadConfig
+	loadConfig = func() (*config, error) { return cfg, nil }
+	t.Cleanup(func() { loadConfig = orig })
+}
+
+// Returns the flags of ffs export, -o being the shorthand of --output
+func exportTestFlags() (*flag.FlagSet, *string) {
+	var output string
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.StringVar(&output, "output", "", "")
+	fs.StringVar(&output, "o", "", "")
+
+	return fs, &output
+}
+
+func TestShorthandBeatsConfig(t *testing.T) {
+	for _, text := range []string{
+		`output = "fromcfg.txt"`,
+		"[export]\noutput = \"fromcfg.txt\"",
+	} {
+		setConfig(t, text)
+		fs, output := exportTestFlags()
+		if err := fs.Parse([]string{"-o", "fromcli.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyConfig(fs); err != nil {
+			t.Fatal(err)
+		}
+
+		if *output != "fromcli.txt" {
+			t.Errorf("%q: got output %q, want fromcli.txt", text, *output)
+		}
+	}
+}
+
+func TestShorthandBeatsEnv(t *testing.T) {
+	setConfig(t, "")
+	t.Setenv("FFS_OUTPUT", "fromenv.txt")
+	t.Setenv("FFS_COUNT", "false")
+
+	fs, output := exportTestFlags()
+	var count bool
+	fs.BoolVar(&count, "count", false, "")
+	fs.BoolVar(&count, "c", false, "")
+	if err := fs.Parse([]string{"-o", "fromcli.txt", "-c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfig(fs); err != nil {
+		t.Fatal(err)
+	}
+
+	if *output != "fromcli.txt" {
+		t.Errorf("got output %q, want fromcli.txt", *output)
+	}
+	if !count {
+		t.Errorf("FFS_COUNT=false replaced -c")
+	}
+}
@@ -0,0 +1,268 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The tables, indexes and views of a database, internal ones excluded
+const schemaQuery = `
+		SELECT type, name, sql
+		FROM sqlite_master
+		WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'
+		ORDER BY rowid`
+
+// Returns the error of a failed query on db. If the database is corrupt,
+// it names the tables that fail the integrity check.
+func queryError(ctx context.Context, db *sql.DB, err error) error {
+	if !isCorrupt(err) {
+		return fmt.Errorf("query failed: %s", err)
+	}
+
+	problems := corruptTables(ctx, db)
+	if len(problems) == 0 {
+		return fmt.Errorf("database is corrupt: %s", err)
+	}
+
+	return fmt.Errorf("database is corrupt: %s", strings.Join(problems, "; "))
+}
+
+// Returns the first problem of every table of db failing the integrity check
+func corruptTables(ctx context.Context, db *sql.DB) []string {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table'")
+	if err != nil {
+		return []string{fmt.Sprintf("schema: %s", err)}
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err == nil {
+			tables = append(tables, table)
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return []string{fmt.Sprintf("schema: %s", err)}
+	}
+
+	var problems []string
+	for _, table := range tables {
+		if problem := checkTable(ctx, db, table); problem != "" {
+			problems = append(problems, table+": "+problem)
+		}
+	}
+
+	return problems
+}
+
+// Returns the first problem the integrity check of table finds, an empty
+// string if there is none
+func checkTable(ctx context.Context, db *sql.DB, table string) string {
+	rows, err := db.QueryContext(ctx, "PRAGMA integrity_check("+sqlQuote(table)+")")
+	if err != nil {
+		return err.Error()
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return err.Error()
+		}
+		// Problems are listed under a line naming the database
+		if result != "ok" && !strings.HasPrefix(result, "***") {
+			return result
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err.Error()
+	}
+
+	return ""
+}
+
+// Replaces the corrupt database copy at path with the rows that can still
+// be read from it and prepares it like copyDatabase does with the database
+// name
+func salvageCopy(ctx context.Context, path, name string) error {
+	salvaged := path + ".salvaged"
+	problems, err := salvageDatabase(ctx, path, salvaged)
+	if err != nil {
+		os.Remove(salvaged)
+		return fmt.Errorf("failed to salvage corrupt database: %s", err)
+	}
+	fmt.Fprintf(os.Stderr, "%s is corrupt, searching what could be salvaged: %s\n", filepath.Base(path), strings.Join(problems, "; "))
+
+	os.Remove(path + "-wal")
+	if err := os.Rename(salvaged, path); err != nil {
+		return fmt.Errorf("failed to salvage corrupt database: %s", err)
+	}
+
+	db, err := sql.Open(driverName, path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return prepareCopy(ctx, db, name)
+}
+
+// Copies the schema of the database at src and all rows that can be read
+// to a new database at dst. Reading a table stops at its first unreadable
+// row and goes on from its last row backwards. Returns the tables that
+// lost rows.
+func salvageDatabase(ctx context.Context, src, dst string) ([]string, error) {
+	from, err := sql.Open(driverName, src)
+	if err != nil {
+		return nil, err
+	}
+	defer from.Close()
+
+	to, err := sql.Open(driverName, dst)
+	if err != nil {
+		return nil, err
+	}
+	defer to.Close()
+
+	type object struct{ kind, name, sql string }
+	rows, err := from.QueryContext(ctx, schemaQuery)
+	if err != nil {
+		return nil, fmt.Errorf("schema: %s", err)
+	}
+	var objects []object
+	for rows.Next() {
+		var o object
+		if err := rows.Scan(&o.kind, &o.name, &o.sql); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("schema: %s", err)
+		}
+		objects = append(objects, o)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("schema: %s", err)
+	}
+
+	var problems []string
+	for _, o := range objects {
+		if o.kind != "table" || strings.HasPrefix(strings.ToUpper(o.sql), "CREATE VIRTUAL") {
+			continue
+		}
+		if _, err := to.ExecContext(ctx, o.sql); err != nil {
+			return nil, fmt.Errorf("%s: %s", o.name, err)
+		}
+
+		n, err := salvageTable(ctx, from, to, o.name)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s, %d rows kept", o.name, err, n))
+		}
+	}
+
+	// Indexes and views are rebuilt from the salvaged rows, triggers are
+	// left out as the copy is never written to
+	for _, o := range objects {
+		if o.kind == "index" || o.kind == "view" {
+			to.ExecContext(ctx, o.sql)
+		}
+	}
+
+	return problems, nil
+}
+
+// Copies the readable rows of table from one database to the other, first
+// in order of their rowid, then after an error in reverse. Returns how many
+// rows were copied and the first error reading them.
+func salvageTable(ctx context.Context, from, to *sql.DB, table string) (int, error) {
+	tx, err := to.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	copied, lastID, readErr := copyRows(ctx, from, tx, table, "ORDER BY rowid", nil)
+	if readErr != nil {
+		var n int
+		n, _, _ = copyRows(ctx, from, tx, table, "ORDER BY rowid DESC", func(id int64) bool { return id > lastID })
+		copied += n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return copied, readErr
+}
+
+// Inserts the rows of table read in the given order into tx while keep, if
+// set, returns true for their rowid. Returns how many rows were inserted,
+// the last rowid read and the error that stopped reading.
+func copyRows(ctx context.Context, from *sql.DB, tx *sql.Tx, table, order string, keep func(int64) bool) (int, int64, error) {
+	rows, err := from.QueryContext(ctx, "SELECT rowid, * FROM "+sqlIdent(table)+" "+order)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, 0, err
+	}
+	names := make([]string, len(columns))
+	for i, column := range columns[1:] {
+		names[i+1] = sqlIdent(column)
+	}
+	names[0] = "rowid"
+	insert, err := tx.PrepareContext(ctx, "INSERT OR IGNORE INTO "+sqlIdent(table)+" ("+strings.Join(names, ", ")+") VALUES (?"+strings.Repeat(", ?", len(names)-1)+")")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer insert.Close()
+
+	var (
+		copied int
+		lastID int64
+	)
+	values := make([]any, len(columns))
+	ptrs := make([]any, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return copied, lastID, err
+		}
+		id, _ := values[0].(int64)
+		if keep != nil && !keep(id) {
+			break
+		}
+		lastID = id
+
+		if _, err := insert.ExecContext(ctx, values...); err != nil {
+			return copied, lastID, err
+		}
+		copied++
+	}
+
+	return copied, lastID, rows.Err()
+}
+
+// Returns s as an SQL string literal
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// Returns s as a quoted SQL identifier
+func sqlIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
@@ -0,0 +1,435 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The daemon takes a new snapshot when the profile changes, this is only
+// a fallback in case a change was missed
+const daemonSnapshotAge = time.Hour
+
+// How long the daemon waits for a client to read a reply before giving up,
+// e.g. on results piped into a pager that is left open
+const daemonWriteTimeout = 30 * time.Second
+
+// A request to the daemon, one per connection
+type daemonRequest struct {
+	// The profile the client would search, the daemon only answers for its own
+	Profile string `json:"profile"`
+	// "ping", "search" or "count", full-text queries with Options.FullText
+	Op      string        `json:"op"`
+	Query   string        `json:"query,omitempty"`
+	Options searchOptions `json:"options"`
+}
+
+// A line of the daemon's answer: results of a search followed by a final
+// line with Done or Error set
+type daemonReply struct {
+	Result *Result `json:"result,omitempty"`
+	Count  int64   `json:"count,omitempty"`
+	Done   bool    `json:"done,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// Returns the path of the daemon's socket, $XDG_RUNTIME_DIR/ffs/daemon.sock
+func daemonSocketPath() string {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = filepath.Join(os.TempDir(), "ffs-"+strconv.Itoa(os.Getuid()))
+	}
+
+	return filepath.Join(runtimeDir, "ffs", "daemon.sock")
+}
+
+// The full-text index the daemon keeps up to date with its snapshot
+type daemonIndex struct {
+	path string
+	db   *sql.DB
+	// Updates take turns, the first one may build the whole index
+	mu sync.Mutex
+}
+
+// Brings the index up to date with the snapshot of places
+func (ix *daemonIndex) update(places *placesSnapshot) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	return places.With(func(db *sql.DB) error {
+		return updateIndex(context.Background(), ix.path, db)
+	})
+}
+
+// The flags of the daemon subcommand
+var daemonFlags struct {
+	socket      string
+	metricsAddr string
+}
+
+// Returns the flag set of the daemon subcommand
+func daemonFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	profileFlag(fs)
+	fs.StringVar(&daemonFlags.socket, "socket", daemonSocketPath(), "`path` of the unix socket to listen on")
+	fs.StringVar(&daemonFlags.metricsAddr, "metrics", "", "serve Prometheus metrics at http://`address`/metrics")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: ffs daemon [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Runs the daemon subcommand, keeping a snapshot of the history and its
+// full-text index that are updated whenever Firefox writes to it and
+// answering searches of the CLI over a unix socket until interrupted
+func runDaemon(args []string) {
+	if _, err := parseArgs(daemonFlagSet(), args); err != nil {
+		os.Exit(exitError)
+	}
+
+	profileDir, err := getFirefoxProfileDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get Mozilla profile directory: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	changes, err := watchPlaces(profileDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to watch %s: %s\n", profileDir, err)
+		os.Exit(exitError)
+	}
+
+	// Started by systemd, the socket is already listening
+	lis, err := activationListener()
+	if err == nil && lis == nil {
+		lis, err = listenUnix(daemonFlags.socket)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitError)
+	}
+	defer lis.Close()
+
+	places := newPlacesSnapshot(profileDir, daemonSnapshotAge)
+	defer places.Close()
+
+	// Take the snapshot now, so the first search is as fast as the others
+	if err := places.With(func(*sql.DB) error { return nil }); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+
+	path, err := indexPath(profileDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitError)
+	}
+	db, err := sql.Open(driverName, path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open index: %s\n", err)
+		os.Exit(exitError)
+	}
+	defer db.Close()
+	index := &daemonIndex{path: path, db: db}
+
+	// Takes a new snapshot and updates the index with it, in the background
+	// so stopping the daemon does not wait for the index to be built
+	refresh := func() {
+		go func() {
+			if err := index.update(places); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+			}
+		}()
+	}
+	refresh()
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	go func() {
+		settle := time.NewTimer(watchSettle)
+		settle.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				lis.Close()
+				return
+			case <-changes:
+				settle.Reset(watchSettle)
+			case <-settle.C:
+				places.Invalidate()
+				refresh()
+			}
+		}
+	}()
+
+	if daemonFlags.metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /metrics", handleMetrics)
+		srv := &http.Server{Addr: daemonFlags.metricsAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+			}
+		}()
+	}
+
+	fmt.Fprintf(os.Stderr, "listening on %s\n", lis.Addr())
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			continue
+		}
+
+		go serveDaemonConn(conn, profileDir, places, index)
+	}
+}
+
+// Listens on the unix socket at path, only accessible by the user.
+// A socket left behind by a daemon that is gone is replaced.
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("could not create socket directory: %s", err)
+	}
+	if err := checkSocketDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("a daemon is already listening on %s", path)
+	}
+	os.Remove(path)
+
+	// Keep others from connecting between listening and chmod
+	oldMask := syscall.Umask(0077)
+	lis, err := net.Listen("unix", path)
+	syscall.Umask(oldMask)
+	if err != nil {
+		return nil, err
+	}
+
+	return lis, nil
+}
+
+// Checks that no one else can replace the socket in dir, which may have
+// been created by another user, e.g. in /tmp without $XDG_RUNTIME_DIR
+func checkSocketDir(dir string) error {
+	for ; ; dir = filepath.Dir(dir) {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("could not check socket directory: %s", err)
+		}
+
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if ok && int(st.Uid) != os.Getuid() && st.Uid != 0 {
+			return fmt.Errorf("socket directory %s belongs to another user", dir)
+		}
+		if info.Mode()&0002 != 0 && info.Mode()&os.ModeSticky == 0 {
+			return fmt.Errorf("socket directory %s is writable by others", dir)
+		}
+
+		if dir == filepath.Dir(dir) {
+			return nil
+		}
+	}
+}
+
+// Reports whether the socket at path belongs to the user, another user
+// could otherwise answer searches in place of the daemon
+func ownSocket(path string) bool {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return false
+	}
+
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int(st.Uid) == os.Getuid()
+}
+
+// Answers the request on conn
+func serveDaemonConn(conn net.Conn, profileDir string, places *placesSnapshot, index *daemonIndex) {
+	defer conn.Close()
+
+	var req daemonRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+
+	w := bufio.NewWriter(deadlineWriter{conn, conn.SetWriteDeadline, daemonWriteTimeout})
+	defer w.Flush()
+	enc := json.NewEncoder(w)
+
+	reply := daemonReply{Done: true}
+	switch {
+	case req.Profile != profileDir:
+		reply = daemonReply{Error: fmt.Sprintf("daemon serves %s", profileDir)}
+	case req.Op == "ping":
+	case req.Op == "count" && req.Options.FullText:
+		start := time.Now()
+		err := index.update(places)
+		if err == nil {
+			reply.Count, err = countFullText(context.Background(), index.db, req.Query)
+		}
+		metrics.observeQuery("daemon", start, 0, err)
+		if err != nil {
+			reply = daemonReply{Error: err.Error()}
+		}
+	case req.Op == "count":
+		start := time.Now()
+		err := places.With(func(db *sql.DB) error {
+			var err error
+			reply.Count, err = countHistory(context.Background(), db, req.Query)
+			return err
+		})
+		metrics.observeQuery("daemon", start, 0, err)
+		if err != nil {
+			reply = daemonReply{Error: err.Error()}
+		}
+	case req.Op == "search" && req.Options.FullText:
+		start := time.Now()
+		sent := 0
+		// Usually up to date already, the snapshot is taken right away
+		err := index.update(places)
+		if err == nil {
+			err = searchHistory(context.Background(), index.db, req.Query, req.Options, func(r *Result) error {
+				sent++
+				return enc.Encode(daemonReply{Result: r})
+			})
+		}
+		metrics.observeQuery("daemon", start, sent, err)
+		if err != nil {
+			reply = daemonReply{Error: err.Error()}
+		}
+	case req.Op == "search":
+		start := time.Now()
+		sent := 0
+		err := places.With(func(db *sql.DB) error {
+			return searchHistory(context.Background(), db, req.Query, req.Options, func(r *Result) error {
+				sent++
+				return enc.Encode(daemonReply{Result: r})
+			})
+		})
+		metrics.observeQuery("daemon", start, sent, err)
+		if err != nil {
+			reply = daemonReply{Error: err.Error()}
+		}
+	default:
+		reply = daemonReply{Error: fmt.Sprintf("unknown op %q", req.Op)}
+	}
+
+	enc.Encode(reply)
+}
+
+// Writes to w, failing if a write does not complete within timeout, so a
+// client that stops reading is given up on
+type deadlineWriter struct {
+	w io.Writer
+	// Sets the write deadline of the connection w writes to
+	setDeadline func(time.Time) error
+	timeout     time.Duration
+}
+
+func (d deadlineWriter) Write(p []byte) (int, error) {
+	if err := d.setDeadline(time.Now().Add(d.timeout)); err != nil {
+		return 0, err
+	}
+
+	return d.w.Write(p)
+}
+
+// Searches the history through a running daemon
+type daemonClient struct {
+	socket     string
+	profileDir string
+}
+
+// Returns a client of the daemon serving profileDir, nil if none is running
+func dialDaemon(profileDir string) *daemonClient {
+	c := &daemonClient{socket: daemonSocketPath(), profileDir: profileDir}
+	if !ownSocket(c.socket) {
+		return nil
+	}
+	if _, err := c.do(context.Background(), daemonRequest{Op: "ping"}, nil); err != nil {
+		return nil
+	}
+
+	return c
+}
+
+// Searches the history for query and calls fn for every distinct URL,
+// like searchHistory
+func (c *daemonClient) Search(ctx context.Context, query string, opts searchOptions, fn func(*Result) error) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	_, err := c.do(ctx, daemonRequest{Op: "search", Query: query, Options: opts}, fn)
+	return err
+}
+
+// Returns the number of distinct URLs in the history matching query, a
+// full-text query of the index if fullText is set
+func (c *daemonClient) Count(ctx context.Context, query string, fullText bool) (int64, error) {
+	return c.do(ctx, daemonRequest{Op: "count", Query: query, Options: searchOptions{FullText: fullText}}, nil)
+}
+
+// Sends req to the daemon and calls fn for every result of the reply.
+// Returns the count of the final line. Once ctx is done, the connection
+// is closed, the daemon stops searching when it sends the next result.
+func (c *daemonClient) do(ctx context.Context, req daemonRequest, fn func(*Result) error) (int64, error) {
+	dialer := net.Dialer{Timeout: time.Second}
+	conn, err := dialer.DialContext(ctx, "unix", c.socket)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	req.Profile = c.profileDir
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return 0, fmt.Errorf("could not send request to daemon: %s", err)
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var reply daemonReply
+		if err := dec.Decode(&reply); err != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			return 0, fmt.Errorf("could not read reply of daemon: %s", err)
+		}
+
+		switch {
+		case reply.Error != "":
+			return 0, errors.New(reply.Error)
+		case reply.Done:
+			return reply.Count, nil
+		case reply.Result != nil && fn != nil:
+			if err := fn(reply.Result); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
@@ -0,0 +1,195 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// How long a connection waits for the lock of another, in milliseconds
+	busyTimeout = 1000
+	// How often a query is tried while the database stays busy or locked
+	busyAttempts = 5
+)
+
+// The PRAGMAs that can be set for the connections reading the history
+var tunablePragmas = []string{"cache_size", "mmap_size", "query_only", "temp_store"}
+
+// The PRAGMAs set with --pragma as name and value, run on every connection
+// reading the history
+var readerPragmas [][2]string
+
+// Values of PRAGMAs, numbers or keywords like "memory"
+var rePragmaValue = regexp.MustCompile(`^(-?[0-9]+|[A-Za-z]+)$`)
+
+// Parses a PRAGMA given as name=value, which must be one of tunablePragmas
+func parsePragma(s string) ([2]string, error) {
+	name, value, ok := strings.Cut(s, "=")
+	name, value = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value)
+	if !ok {
+		return [2]string{}, fmt.Errorf("pragma %q is not name=value", s)
+	}
+	if !slices.Contains(tunablePragmas, name) {
+		return [2]string{}, fmt.Errorf("unknown pragma %q (available: %s)", name, strings.Join(tunablePragmas, ", "))
+	}
+	if !rePragmaValue.MatchString(value) {
+		return [2]string{}, fmt.Errorf("invalid value %q of pragma %s", value, name)
+	}
+
+	return [2]string{name, value}, nil
+}
+
+// Calls fn until it does not fail with a busy or locked database, waiting
+// longer after every attempt. Gives up after busyAttempts or when ctx is done.
+func retryBusy(ctx context.Context, fn func() error) error {
+	wait := 50 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isBusy(err) || attempt == busyAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// Returns s in NFC and case folded, so text saved decomposed (as on macOS)
+// and in any case matches patterns folded alike. Implements the
+// ffs_fold(text) SQL function.
+func foldText(s string) string {
+	if !isASCII(s) {
+		return cases.Fold().String(norm.NFC.String(s))
+	}
+
+	// ASCII is already in NFC and folds like it lowercases
+	return strings.ToLower(s)
+}
+
+// Reports whether s is ASCII only
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Implements the ffs_decay(visit_date, half_life_seconds) SQL function,
+// the weight of a visit halving every half-life
+func decay(visitDate int64, halfLife float64) float64 {
+	age := max(time.Since(prTimeToTime(visitDate)).Seconds(), 0)
+	return math.Exp2(-age / halfLife)
+}
+
+// Returns the implementation of the ffs_relevance SQL function:
+//
+//	ffs_relevance(query, match_weight, frecency_weight, recency_weight,
+//	              url, title, description, frecency, last_visit_date)
+func relevanceFunc() func(query string, wm, wf, wr float64, url, title, description, frecency, lastVisit any) float64 {
+	// The query is the same for every row, only compile it once
+	var (
+		lastQuery string
+		re        *regexp.Regexp
+	)
+
+	return func(query string, wm, wf, wr float64, url, title, description, frecency, lastVisit any) float64 {
+		// Like the search, the query matches folded text
+		if query != lastQuery || re == nil {
+			lastQuery, re = query, matchRegexp(foldText(query))
+		}
+		text := []string{sqlString(url), sqlString(title), sqlString(description)}
+		for i := range text {
+			text[i] = foldText(text[i])
+		}
+
+		var visit time.Time
+		if v := sqlInt64(lastVisit); v != 0 {
+			visit = prTimeToTime(v)
+		}
+
+		w := relevanceWeights{Match: wm, Frecency: wf, Recency: wr}
+		return relevance(re, w, text[0], text[1], text[2], sqlInt64(frecency), visit, time.Now())
+	}
+}
+
+// Converts a generic SQL function argument to a string, NULL is empty
+func sqlString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+
+	return ""
+}
+
+// Converts a generic SQL function argument to a float64, NULL is 0
+func sqlFloat64(v any) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+
+	return 0
+}
+
+// Converts a generic SQL function argument to an int64, NULL is 0
+func sqlInt64(v any) int64 {
+	switch v := v.(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+
+	return 0
+}
+
+// Returns the language of the users collation locale,
+// the root locale if it is not set or unknown
+func userLanguage() language.Tag {
+	for _, env := range []string{"LC_ALL", "LC_COLLATE", "LANG"} {
+		locale := os.Getenv(env)
+		if locale == "" {
+			continue
+		}
+
+		// e.g. de_DE.UTF-8@euro
+		locale, _, _ = strings.Cut(locale, ".")
+		locale, _, _ = strings.Cut(locale, "@")
+		if locale == "C" || locale == "POSIX" {
+			return language.Und
+		}
+
+		tag, err := language.Parse(strings.ReplaceAll(locale, "_", "-"))
+		if err != nil {
+			return language.Und
+		}
+		return tag
+	}
+
+	return language.Und
+}
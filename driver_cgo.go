@@ -0,0 +1,93 @@
+//go:build linux && cgo
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"strconv"
+
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/text/collate"
+)
+
+// The name of the SQLite driver with the ffs extensions registered, which
+// everything opens databases through
+const driverName = "sqlite3_ffs"
+
+// The full-text search module of the driver, go-sqlite3 only has FTS5 with
+// a build tag
+const ftsModule = "fts4"
+
+// The name of the driver the history is read with, which also runs the
+// readerPragmas, go-sqlite3 only takes a few of them as URI parameters
+const readerDriverName = "sqlite3_ffs_reader"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{ConnectHook: registerExtensions})
+
+	sql.Register(readerDriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := registerExtensions(conn); err != nil {
+				return err
+			}
+
+			for _, p := range readerPragmas {
+				if _, err := conn.Exec("PRAGMA "+p[0]+" = "+p[1], nil); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	})
+}
+
+// Registers the ffs functions and collations on a new connection
+func registerExtensions(conn *sqlite3.SQLiteConn) error {
+	if _, err := conn.Exec("PRAGMA busy_timeout = "+strconv.Itoa(busyTimeout), nil); err != nil {
+		return err
+	}
+
+	// Collators are not safe for concurrent use, every connection gets its own
+	c := collate.New(userLanguage(), collate.Loose)
+	if err := conn.RegisterCollation("LOCALE", func(a, b string) int {
+		return c.CompareString(a, b)
+	}); err != nil {
+		return err
+	}
+
+	if err := conn.RegisterFunc("ffs_fold", func(v any) any {
+		if v == nil {
+			return nil
+		}
+		return foldText(sqlString(v))
+	}, true); err != nil {
+		return err
+	}
+
+	if err := conn.RegisterFunc("ffs_decay", decay, false); err != nil {
+		return err
+	}
+
+	return conn.RegisterFunc("ffs_relevance", relevanceFunc(), false)
+}
+
+// Opens the database at the file: URI uri to read the history with the
+// readerPragmas
+func openReader(uri url.URL) (*sql.DB, error) {
+	return sql.Open(readerDriverName, uri.String())
+}
+
+// Reports whether err means the database was busy or locked
+func isBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+// Reports whether err means the database file is corrupt
+func isCorrupt(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrCorrupt || sqliteErr.Code == sqlite3.ErrNotADB)
+}
@@ -0,0 +1,98 @@
+//go:build linux && !cgo
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"golang.org/x/text/collate"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// The name of the SQLite driver with the ffs extensions registered, which
+// everything opens databases through. Without cgo, it is the pure Go
+// modernc.org/sqlite, so ffs can be built statically and cross-compiled.
+const driverName = "sqlite"
+
+// The full-text search module of the driver, modernc.org/sqlite only has FTS5
+const ftsModule = "fts5"
+
+// The functions and collations of modernc.org/sqlite are registered for all
+// connections at once, so they share state behind a lock
+func init() {
+	sqlite.RegisterConnectionHook(func(conn sqlite.ExecQuerierContext, _ string) error {
+		_, err := conn.ExecContext(context.Background(), "PRAGMA busy_timeout = "+strconv.Itoa(busyTimeout), nil)
+		return err
+	})
+
+	var collatorMu sync.Mutex
+	c := collate.New(userLanguage(), collate.Loose)
+	sqlite.MustRegisterCollationUtf8("LOCALE", func(a, b string) int {
+		collatorMu.Lock()
+		defer collatorMu.Unlock()
+
+		return c.CompareString(a, b)
+	})
+
+	sqlite.MustRegisterDeterministicScalarFunction("ffs_fold", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		return foldText(sqlString(args[0])), nil
+	})
+
+	sqlite.MustRegisterScalarFunction("ffs_decay", 2, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		return decay(sqlInt64(args[0]), sqlFloat64(args[1])), nil
+	})
+
+	var relevanceMu sync.Mutex
+	relevance := relevanceFunc()
+	sqlite.MustRegisterScalarFunction("ffs_relevance", 9, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		relevanceMu.Lock()
+		defer relevanceMu.Unlock()
+
+		return relevance(sqlString(args[0]), sqlFloat64(args[1]), sqlFloat64(args[2]), sqlFloat64(args[3]),
+			args[4], args[5], args[6], args[7], args[8]), nil
+	})
+}
+
+// Opens the database at the file: URI uri to read the history with the
+// readerPragmas, which modernc.org/sqlite runs from _pragma parameters
+func openReader(uri url.URL) (*sql.DB, error) {
+	q := uri.Query()
+	for _, p := range readerPragmas {
+		q.Add("_pragma", p[0]+"("+p[1]+")")
+	}
+	uri.RawQuery = q.Encode()
+
+	return sql.Open(driverName, uri.String())
+}
+
+// Reports whether err means the database was busy or locked
+func isBusy(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+// Reports whether err means the database file is corrupt
+func isCorrupt(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_CORRUPT || code == sqlite3.SQLITE_NOTADB
+}
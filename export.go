@@ -0,0 +1,189 @@
+//go:build linux
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"time"
+)
+
+// The flags of the export subcommand
+var exportFlags struct {
+	format    string
+	bookmarks bool
+	output    string
+}
+
+// Returns the flag set of the export subcommand
+func exportFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	profileFlag(fs)
+	fs.StringVar(&exportFlags.format, "format", "netscape", "export format, currently only \"netscape\"")
+	fs.BoolVar(&exportFlags.bookmarks, "bookmarks", false, "export matching bookmarks instead of history")
+	fs.StringVar(&exportFlags.output, "output", "", "write the export to `file` instead of stdout (\"-\" for stdout)")
+	fs.StringVar(&exportFlags.output, "o", "", "shorthand for --output")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: ffs export [flags] \"<query>\"\n\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Runs the export subcommand
+func runExport(args []string) {
+	fs := exportFlagSet()
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		os.Exit(exitError)
+	}
+
+	if len(args) < 1 || args[0] == "" {
+		fs.Usage()
+		os.Exit(exitError)
+	}
+	query := args[0]
+	if err := validateGlob(query); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitError)
+	}
+
+	if exportFlags.format != "netscape" {
+		fmt.Fprintf(os.Stderr, "unknown export format %q (available: netscape)\n", exportFlags.format)
+		os.Exit(exitError)
+	}
+
+	profileDir, err := getFirefoxProfileDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get Mozilla profile directory: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	db, cleanup, err := openPlaces(ctx, profileDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", contextError(ctx, err))
+		os.Exit(exitCode(exitError))
+	}
+	defer cleanup()
+
+	dst, err := openOutput(exportFlags.output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitError)
+	}
+
+	title := "ffs: " + query
+	if exportFlags.bookmarks {
+		title = "ffs bookmarks: " + query
+	}
+	out := newNetscapeWriter(dst, title)
+
+	matches := 0
+	write := writeTo(out)
+	count := func(r *Result) error {
+		matches++
+		return write(r)
+	}
+	if exportFlags.bookmarks {
+		err = searchBookmarks(ctx, db, query, searchOptions{}, count)
+	} else {
+		err = searchHistory(ctx, db, query, defaultSearchOptions, count)
+	}
+	if err != nil {
+		dst.Abort()
+		fmt.Fprintf(os.Stderr, "%s\n", contextError(ctx, err))
+		os.Exit(exitCode(exitError))
+	}
+
+	if err := out.Flush(); err != nil {
+		dst.Abort()
+		fmt.Fprintf(os.Stderr, "error writing results: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	if err := dst.Commit(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitError)
+	}
+
+	// The empty export is still written
+	if matches == 0 {
+		cleanup()
+		os.Exit(exitNoMatch)
+	}
+}
+
+// Writes results as a Netscape bookmark file, importable by most browsers.
+// All results are placed in a single folder named after the query.
+type netscapeWriter struct {
+	w          io.Writer
+	folder     string
+	headerDone bool
+}
+
+func newNetscapeWriter(w io.Writer, folder string) *netscapeWriter {
+	return &netscapeWriter{w: w, folder: folder}
+}
+
+func (n *netscapeWriter) WriteResult(r *Result) error {
+	if err := n.writeHeader(); err != nil {
+		return err
+	}
+
+	added := r.Added
+	if added.IsZero() {
+		added = r.LastVisit
+	}
+
+	title := r.Title
+	if title == "" {
+		title = r.URL
+	}
+
+	attrs := fmt.Sprintf(` HREF="%s"`, html.EscapeString(r.URL))
+	if !added.IsZero() {
+		attrs += fmt.Sprintf(` ADD_DATE="%d"`, added.Unix())
+	}
+	if !r.LastVisit.IsZero() {
+		attrs += fmt.Sprintf(` LAST_VISIT="%d"`, r.LastVisit.Unix())
+	}
+
+	_, err := fmt.Fprintf(n.w, "        <DT><A%s>%s</A>\n", attrs, html.EscapeString(title))
+	return err
+}
+
+func (n *netscapeWriter) Flush() error {
+	if err := n.writeHeader(); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(n.w, "    </DL><p>\n</DL><p>\n")
+	return err
+}
+
+// Writes the file preamble and opens the folder once
+func (n *netscapeWriter) writeHeader() error {
+	if n.headerDone {
+		return nil
+	}
+	n.headerDone = true
+
+	_, err := fmt.Fprintf(n.w, `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<!-- This is an automatically generated file.
+     It will be read and overwritten.
+     DO NOT EDIT! -->
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+    <DT><H3 ADD_DATE="%d">%s</H3>
+    <DL><p>
+`, time.Now().Unix(), html.EscapeString(n.folder))
+	return err
+}
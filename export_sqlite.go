@@ -0,0 +1,154 @@
+//go:build linux
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The schema of databases written by --export-sqlite
+const exportSchema = `
+	CREATE TABLE meta (
+		key   TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+	CREATE TABLE results (
+		id          INTEGER PRIMARY KEY,
+		url         TEXT NOT NULL,
+		title       TEXT,
+		description TEXT,
+		visit_count INTEGER NOT NULL DEFAULT 0,
+		frecency    INTEGER NOT NULL DEFAULT 0,
+		last_visit  TEXT
+	);
+	CREATE INDEX results_url ON results (url);`
+
+// Writes results into a freshly created SQLite database. It is written to
+// a temporary file next to the target, which is only moved into place by
+// Flush, so a failed or interrupted export leaves nothing behind.
+type sqliteWriter struct {
+	db   *sql.DB
+	tx   *sql.Tx
+	stmt *sql.Stmt
+	path string
+	tmp  string
+}
+
+// Creates the database for path, which must not exist yet
+func newSQLiteWriter(path, query string) (*sqliteWriter, error) {
+	// Checked again by Flush, this only fails before searching
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("%s already exists", path)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create database: %s", err)
+	}
+	f.Close()
+	tmp := f.Name()
+
+	db, err := sql.Open(driverName, tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to create database: %s", err)
+	}
+
+	if _, err := db.Exec(exportSchema); err != nil {
+		db.Close()
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to create schema: %s", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	meta := map[string]string{
+		"query":       query,
+		"exported_at": time.Now().UTC().Format(time.RFC3339),
+	}
+	for key, value := range meta {
+		if _, err := tx.Exec(`INSERT INTO meta (key, value) VALUES (?, ?)`, key, value); err != nil {
+			tx.Rollback()
+			db.Close()
+			os.Remove(tmp)
+			return nil, err
+		}
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO results (url, title, description, visit_count, frecency, last_visit)
+		VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		db.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	return &sqliteWriter{db: db, tx: tx, stmt: stmt, path: path, tmp: tmp}, nil
+}
+
+func (s *sqliteWriter) WriteResult(r *Result) error {
+	var lastVisit any
+	if !r.LastVisit.IsZero() {
+		lastVisit = r.LastVisit.UTC().Format(time.RFC3339)
+	}
+
+	_, err := s.stmt.Exec(r.URL, nullString(r.Title), nullString(r.Description), r.VisitCount, r.Frecency, lastVisit)
+	return err
+}
+
+// Commits the database and moves it into place
+func (s *sqliteWriter) Flush() error {
+	defer os.Remove(s.tmp)
+
+	s.stmt.Close()
+	if err := s.tx.Commit(); err != nil {
+		s.db.Close()
+		return err
+	}
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(s.tmp, 0644); err != nil {
+		return fmt.Errorf("failed to write database: %s", err)
+	}
+
+	// Unlike renaming, linking fails if the target was created meanwhile
+	if err := os.Link(s.tmp, s.path); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%s already exists", s.path)
+		}
+		return fmt.Errorf("failed to write database: %s", err)
+	}
+
+	return nil
+}
+
+// Discards the database, leaving no file behind
+func (s *sqliteWriter) Abort() {
+	s.stmt.Close()
+	s.tx.Rollback()
+	s.db.Close()
+	os.Remove(s.tmp)
+}
+
+// Returns nil for empty strings so they are stored as NULL
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+
+	return s
+}
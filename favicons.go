@@ -0,0 +1,117 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The SQL query to get the smallest favicon of a page
+const faviconQuery = `
+		SELECT moz_icons.data
+		FROM moz_pages_w_icons
+		JOIN moz_icons_to_pages ON moz_icons_to_pages.page_id = moz_pages_w_icons.id
+		JOIN moz_icons ON moz_icons.id = moz_icons_to_pages.icon_id
+		WHERE moz_pages_w_icons.page_url = ? AND moz_icons.data IS NOT NULL
+		ORDER BY moz_icons.width ASC
+		LIMIT 1`
+
+// Looks up favicons in a copy of the profiles favicons.sqlite
+type faviconStore struct {
+	db *sql.DB
+}
+
+// Opens a cached copy of the favicons.sqlite in profileDir.
+// The returned cleanup func closes it.
+func openFaviconStore(profileDir string) (*faviconStore, func(), error) {
+	path, err := cachedCopy(context.Background(), profileDir, "favicons.sqlite")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db, err := openImmutable(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &faviconStore{db: db}, func() { db.Close() }, nil
+}
+
+// Returns the favicon of url as a data URI, or an empty string if there is none
+func (f *faviconStore) DataURI(url string) string {
+	data, mime := f.lookup(url)
+	if data == nil {
+		return ""
+	}
+
+	return "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// Returns the path of the favicon of url written to dir, or an empty
+// string if there is none. Icons already in dir are reused.
+func (f *faviconStore) File(url, dir string) string {
+	data, mime := f.lookup(url)
+	if data == nil {
+		return ""
+	}
+
+	ext := ".png"
+	switch mime {
+	case "image/svg+xml":
+		ext = ".svg"
+	case "image/x-icon", "image/vnd.microsoft.icon":
+		ext = ".ico"
+	}
+
+	sum := sha1.Sum(data)
+	path := filepath.Join(dir, hex.EncodeToString(sum[:])+ext)
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return ""
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return ""
+	}
+
+	return path
+}
+
+// Returns the directory favicons are written to for tools that need them
+// as files, $XDG_CACHE_HOME/ffs/icons
+func faviconCacheDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(cacheDir, "ffs", "icons"), nil
+}
+
+// Returns the favicon data of url and its MIME type, nil if there is none
+func (f *faviconStore) lookup(url string) ([]byte, string) {
+	var data []byte
+	if err := f.db.QueryRow(faviconQuery, url).Scan(&data); err != nil || len(data) == 0 {
+		return nil, ""
+	}
+
+	mime := http.DetectContentType(data)
+	if strings.Contains(string(data[:min(len(data), 512)]), "<svg") {
+		mime = "image/svg+xml"
+	}
+	if !strings.HasPrefix(mime, "image/") {
+		return nil, ""
+	}
+
+	return data, mime
+}
@@ -0,0 +1,254 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        v5.29.3
+// source: ffs.proto
+
+package ffspb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SearchRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Matched against URLs, titles and descriptions, * and ? are wildcards
+	Query string `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	// date (default), frecency, visits, hot, relevance, url or title
+	Sort string `protobuf:"bytes,2,opt,name=sort,proto3" json:"sort,omitempty"`
+	// Flips the order
+	Reverse bool `protobuf:"varint,3,opt,name=reverse,proto3" json:"reverse,omitempty"`
+	// The maximum number of results, 0 for all
+	Limit         uint32 `protobuf:"varint,4,opt,name=limit,proto3" json:"limit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchRequest) Reset() {
+	*x = SearchRequest{}
+	mi := &file_ffs_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchRequest) ProtoMessage() {}
+
+func (x *SearchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_ffs_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchRequest.ProtoReflect.Descriptor instead.
+func (*SearchRequest) Descriptor() ([]byte, []int) {
+	return file_ffs_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SearchRequest) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetSort() string {
+	if x != nil {
+		return x.Sort
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetReverse() bool {
+	if x != nil {
+		return x.Reverse
+	}
+	return false
+}
+
+func (x *SearchRequest) GetLimit() uint32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type Result struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Url           string                 `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+	Title         string                 `protobuf:"bytes,2,opt,name=title,proto3" json:"title,omitempty"`
+	Description   string                 `protobuf:"bytes,3,opt,name=description,proto3" json:"description,omitempty"`
+	VisitCount    int64                  `protobuf:"varint,4,opt,name=visit_count,json=visitCount,proto3" json:"visit_count,omitempty"`
+	Frecency      int64                  `protobuf:"varint,5,opt,name=frecency,proto3" json:"frecency,omitempty"`
+	LastVisit     *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=last_visit,json=lastVisit,proto3" json:"last_visit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Result) Reset() {
+	*x = Result{}
+	mi := &file_ffs_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Result) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Result) ProtoMessage() {}
+
+func (x *Result) ProtoReflect() protoreflect.Message {
+	mi := &file_ffs_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Result.ProtoReflect.Descriptor instead.
+func (*Result) Descriptor() ([]byte, []int) {
+	return file_ffs_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Result) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *Result) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Result) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *Result) GetVisitCount() int64 {
+	if x != nil {
+		return x.VisitCount
+	}
+	return 0
+}
+
+func (x *Result) GetFrecency() int64 {
+	if x != nil {
+		return x.Frecency
+	}
+	return 0
+}
+
+func (x *Result) GetLastVisit() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastVisit
+	}
+	return nil
+}
+
+var File_ffs_proto protoreflect.FileDescriptor
+
+const file_ffs_proto_rawDesc = "" +
+	"\n" +
+	"\tffs.proto\x12\x06ffs.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"i\n" +
+	"\rSearchRequest\x12\x14\n" +
+	"\x05query\x18\x01 \x01(\tR\x05query\x12\x12\n" +
+	"\x04sort\x18\x02 \x01(\tR\x04sort\x12\x18\n" +
+	"\areverse\x18\x03 \x01(\bR\areverse\x12\x14\n" +
+	"\x05limit\x18\x04 \x01(\rR\x05limit\"\xca\x01\n" +
+	"\x06Result\x12\x10\n" +
+	"\x03url\x18\x01 \x01(\tR\x03url\x12\x14\n" +
+	"\x05title\x18\x02 \x01(\tR\x05title\x12 \n" +
+	"\vdescription\x18\x03 \x01(\tR\vdescription\x12\x1f\n" +
+	"\vvisit_count\x18\x04 \x01(\x03R\n" +
+	"visitCount\x12\x1a\n" +
+	"\bfrecency\x18\x05 \x01(\x03R\bfrecency\x129\n" +
+	"\n" +
+	"last_visit\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\tlastVisit2<\n" +
+	"\aHistory\x121\n" +
+	"\x06Search\x12\x15.ffs.v1.SearchRequest\x1a\x0e.ffs.v1.Result0\x01B\vZ\tffs/ffspbb\x06proto3"
+
+var (
+	file_ffs_proto_rawDescOnce sync.Once
+	file_ffs_proto_rawDescData []byte
+)
+
+func file_ffs_proto_rawDescGZIP() []byte {
+	file_ffs_proto_rawDescOnce.Do(func() {
+		file_ffs_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_ffs_proto_rawDesc), len(file_ffs_proto_rawDesc)))
+	})
+	return file_ffs_proto_rawDescData
+}
+
+var file_ffs_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_ffs_proto_goTypes = []any{
+	(*SearchRequest)(nil),         // 0: ffs.v1.SearchRequest
+	(*Result)(nil),                // 1: ffs.v1.Result
+	(*timestamppb.Timestamp)(nil), // 2: google.protobuf.Timestamp
+}
+var file_ffs_proto_depIdxs = []int32{
+	2, // 0: ffs.v1.Result.last_visit:type_name -> google.protobuf.Timestamp
+	0, // 1: ffs.v1.History.Search:input_type -> ffs.v1.SearchRequest
+	1, // 2: ffs.v1.History.Search:output_type -> ffs.v1.Result
+	2, // [2:3] is the sub-list for method output_type
+	1, // [1:2] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_ffs_proto_init() }
+func file_ffs_proto_init() {
+	if File_ffs_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_ffs_proto_rawDesc), len(file_ffs_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_ffs_proto_goTypes,
+		DependencyIndexes: file_ffs_proto_depIdxs,
+		MessageInfos:      file_ffs_proto_msgTypes,
+	}.Build()
+	File_ffs_proto = out.File
+	file_ffs_proto_goTypes = nil
+	file_ffs_proto_depIdxs = nil
+}
@@ -0,0 +1,4 @@
+// Package ffspb holds the gRPC service of ffs serve --grpc, generated from ffs.proto
+package ffspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative ffs.proto
@@ -0,0 +1,91 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"ffs/ffspb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Implements the History service of ffspb/ffs.proto
+type historyServer struct {
+	ffspb.UnimplementedHistoryServer
+	places *placesSnapshot
+}
+
+// Serves the gRPC History service on lis until ctx is done
+func (s *server) serveGRPC(ctx context.Context, lis net.Listener) error {
+	srv := grpc.NewServer()
+	ffspb.RegisterHistoryServer(srv, &historyServer{places: s.places})
+
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+	}()
+
+	fmt.Fprintf(os.Stderr, "serving gRPC on %s\n", lis.Addr())
+	return srv.Serve(lis)
+}
+
+// Streams the results of a search as they are read from the history
+func (h *historyServer) Search(req *ffspb.SearchRequest, stream grpc.ServerStreamingServer[ffspb.Result]) error {
+	if req.GetQuery() == "" {
+		return status.Error(codes.InvalidArgument, "empty query")
+	}
+
+	opts := defaultSearchOptions
+	if req.GetSort() != "" {
+		opts.Sort = req.GetSort()
+	}
+	opts.Reverse = req.GetReverse()
+	opts.Limit = int(req.GetLimit())
+	if err := opts.validate(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	start := time.Now()
+	sent := 0
+	err := h.places.With(func(db *sql.DB) error {
+		// Stops reading once the client is gone
+		return searchHistory(stream.Context(), db, req.GetQuery(), opts, func(res *Result) error {
+			sent++
+			return stream.Send(newProtoResult(res))
+		})
+	})
+	metrics.observeQuery("grpc", start, sent, err)
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	return nil
+}
+
+// Converts a Result to its protobuf message
+func newProtoResult(r *Result) *ffspb.Result {
+	res := &ffspb.Result{
+		Url:         r.URL,
+		Title:       r.Title,
+		Description: r.Description,
+		VisitCount:  r.VisitCount,
+		Frecency:    r.Frecency,
+	}
+	if !r.LastVisit.IsZero() {
+		res.LastVisit = timestamppb.New(r.LastVisit)
+	}
+
+	return res
+}
@@ -0,0 +1,635 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// The visited pages filtered by the argument as a glob pattern
+	histFrom = `
+		FROM moz_places
+		WHERE moz_places.id IN (SELECT place_id FROM moz_historyvisits)
+			AND (LOWER(url) GLOB LOWER(?) OR LOWER(title) GLOB LOWER(?) OR LOWER(description) GLOB LOWER(?))`
+	// The visited pages in the full-text index matching the argument
+	ftsFrom = `
+		FROM moz_places
+		WHERE moz_places.id IN (SELECT place_id FROM moz_historyvisits)
+			AND moz_places.id IN (SELECT rowid FROM ffs_fts WHERE ffs_fts MATCH ?)`
+	// Groups the pages into one row per URL
+	histGroupBy = `
+		GROUP BY url`
+	// The SQL query to count the distinct URLs in the history
+	histCountQuery = `
+		SELECT COUNT(DISTINCT url)` + histFrom
+	// The SQL query to count the distinct URLs in the full-text index
+	ftsCountQuery = `
+		SELECT COUNT(DISTINCT url)` + ftsFrom
+	// The SQL query to get the most recent visits of a URL
+	visitsQuery = `
+		SELECT visit_date
+		FROM moz_historyvisits
+		JOIN moz_places ON moz_places.id = moz_historyvisits.place_id
+		WHERE url = ?
+		ORDER BY visit_date DESC
+		LIMIT ?`
+	// The SQL query to get the id of the latest visit
+	lastVisitIDQuery = `
+		SELECT COALESCE(MAX(id), 0) FROM moz_historyvisits`
+	// The SQL query to get the visits after a visit id filtered by the argument as a glob pattern
+	newVisitsQuery = `
+		SELECT moz_historyvisits.id, url, title, description, visit_count, frecency, visit_date
+		FROM moz_historyvisits
+		JOIN moz_places ON moz_places.id = moz_historyvisits.place_id
+		WHERE moz_historyvisits.id > ? AND (LOWER(url) GLOB LOWER(?) OR LOWER(title) GLOB LOWER(?) OR LOWER(description) GLOB LOWER(?))
+		ORDER BY moz_historyvisits.id ASC`
+	// The SQL query to get a single page by its URL
+	pageQuery = `
+		SELECT url, title, description, visit_count, frecency, last_visit_date
+		FROM moz_places
+		WHERE url = ?`
+	// The SQL query to get the bookmark titles of a URL
+	bookmarkedQuery = `
+		SELECT COALESCE(moz_bookmarks.title, '')
+		FROM moz_bookmarks
+		JOIN moz_places ON moz_places.id = moz_bookmarks.fk
+		WHERE moz_bookmarks.type = 1 AND url = ?`
+	// The SQL query to get the bookmarks filtered by the argument as a glob pattern
+	bookmarksQuery = `
+		SELECT url, moz_bookmarks.title, description, visit_count, frecency, last_visit_date, moz_bookmarks.dateAdded
+		FROM moz_bookmarks
+		JOIN moz_places ON moz_places.id = moz_bookmarks.fk
+		WHERE moz_bookmarks.type = 1 AND (LOWER(url) GLOB LOWER(?) OR LOWER(moz_bookmarks.title) GLOB LOWER(?) OR LOWER(description) GLOB LOWER(?))
+		ORDER BY moz_bookmarks.dateAdded ASC`
+)
+
+// A way of sorting the history
+type sortOrder struct {
+	// The expression to sort by
+	expr string
+	// Whether the expression is sorted descending by default
+	desc bool
+	// Returns the arguments for placeholders in expr, if any
+	args func(o searchOptions, query string) []any
+}
+
+// How the history can be sorted, by --sort value
+var sortOrders = map[string]sortOrder{
+	"date":     {expr: "last_visit_date", desc: true},
+	"frecency": {expr: "frecency", desc: true},
+	"visits":   {expr: "visit_count", desc: true},
+	"url":      {expr: "url COLLATE LOCALE"},
+	"title":    {expr: "title COLLATE LOCALE"},
+	"hot": {
+		expr: "(SELECT SUM(ffs_decay(visit_date, ?)) FROM moz_historyvisits AS v WHERE v.place_id = moz_places.id)",
+		desc: true,
+		args: func(o searchOptions, query string) []any {
+			return []any{o.HalfLife.Seconds()}
+		},
+	},
+	"relevance": {
+		expr: "ffs_relevance(?, ?, ?, ?, url, title, description, frecency, last_visit_date)",
+		desc: true,
+		args: func(o searchOptions, query string) []any {
+			return []any{query, o.Weights.Match, o.Weights.Frecency, o.Weights.Recency}
+		},
+	},
+}
+
+// Options for searching the history
+type searchOptions struct {
+	// How results are ordered, one of the keys of sortOrders
+	Sort string
+	// Reverses the order
+	Reverse bool
+	// Weights for sorting by relevance
+	Weights relevanceWeights
+	// After how long a visit counts half when sorting by hotness
+	HalfLife time.Duration
+	// The maximum number of results, 0 for all
+	Limit int
+	// The output columns the results are printed with, nil if all fields
+	// of the results are used. The fields of other columns are left empty.
+	Columns []string
+	// Whether the query is a full-text query of the index of openIndex
+	// instead of a glob pattern
+	FullText bool
+	// Domains whose pages, also of their subdomains, are left out
+	Exclude []string
+}
+
+// The options used when none are given
+var defaultSearchOptions = searchOptions{Sort: "date", Weights: defaultRelevanceWeights, HalfLife: defaultHalfLife}
+
+// The default half-life of visits when sorting by hotness
+const defaultHalfLife = 7 * 24 * time.Hour
+
+// Checks that the options are valid
+func (o searchOptions) validate() error {
+	if _, ok := sortOrders[o.Sort]; !ok {
+		names := slices.Sorted(maps.Keys(sortOrders))
+		return fmt.Errorf("unknown sort order %q (available: %s)", o.Sort, strings.Join(names, ", "))
+	}
+
+	if o.Sort == "hot" && o.HalfLife <= 0 {
+		return fmt.Errorf("half-life must be positive")
+	}
+
+	if o.Limit < 0 {
+		return fmt.Errorf("limit must not be negative")
+	}
+
+	return nil
+}
+
+// Returns the ORDER BY clause for the options and its arguments.
+// Ties are broken by the place id (reversed with Reverse), so the
+// same database always yields the same order.
+func (o searchOptions) orderBy(query string) (string, []any) {
+	order := sortOrders[o.Sort]
+
+	dir := "ASC"
+	if order.desc != o.Reverse {
+		dir = "DESC"
+	}
+
+	tiebreak := "ASC"
+	if o.Reverse {
+		tiebreak = "DESC"
+	}
+
+	var args []any
+	if order.args != nil {
+		args = order.args(o, query)
+	}
+
+	return "ORDER BY " + order.expr + " " + dir + ", moz_places.id " + tiebreak, args
+}
+
+// The columns of moz_places the fields of a Result are read from, in the
+// order scanResult reads them, with the output column each is printed in.
+// The URL is always read.
+var resultFields = []struct{ column, sql string }{
+	{"title", "title"},
+	{"", "description"},
+	{"visits", "visit_count"},
+	{"frecency", "frecency"},
+	{"date", "last_visit_date"},
+}
+
+// Returns the SELECT clause of the fields of the results. Fields of columns
+// that are not printed are selected as NULL, so their values are not read.
+func (o searchOptions) selectColumns() string {
+	fields := []string{"url"}
+	for _, f := range resultFields {
+		if o.Columns == nil || (f.column != "" && slices.Contains(o.Columns, f.column)) {
+			fields = append(fields, f.sql)
+		} else {
+			fields = append(fields, "NULL")
+		}
+	}
+
+	return "\n\t\tSELECT " + strings.Join(fields, ", ")
+}
+
+// Can be returned by search callbacks to end a search early without an error
+var errStopSearch = errors.New("stop search")
+
+// Opens the places.sqlite of profileDir read-only in place if no Firefox
+// has the profile open. If one does, that fails, there are writes in its WAL
+// or it lacks columns of newer profiles, a cached copy is opened instead,
+// unless there is no room for it. The returned cleanup func closes the
+// database.
+func openPlaces(ctx context.Context, profileDir string) (*sql.DB, func(), error) {
+	// A running Firefox may checkpoint into the database while it is read in
+	// place, and recent writes may only be in the WAL, which is not read
+	wal, err := os.Stat(profileDir + "/places.sqlite-wal")
+	if firefoxPID(profileDir) == 0 && (err != nil || wal.Size() == 0) {
+		db, err := openImmutable(profileDir + "/places.sqlite")
+		if err == nil {
+			// Old profiles lack columns only the copy has
+			if ok, err := hasColumn(ctx, db, "moz_places", "description"); err == nil && ok {
+				return db, func() { db.Close() }, nil
+			}
+			db.Close()
+		}
+	}
+
+	db, cleanup, err := openCachedPlaces(ctx, profileDir)
+	// Without room for a copy, what is not yet in the WAL is still found
+	var noSpace *noSpaceError
+	if errors.As(err, &noSpace) {
+		fmt.Fprintf(os.Stderr, "%s, reading places.sqlite in place without the latest visits\n", err)
+		db, err := openImmutable(profileDir + "/places.sqlite")
+		if err != nil {
+			return nil, nil, err
+		}
+		return db, func() { db.Close() }, nil
+	}
+
+	return db, cleanup, err
+}
+
+// Opens the SQLite database at path read-only without locking it. SQLite
+// assumes it does not change while open, so it is only used for short runs,
+// long-lived snapshots are copies.
+func openImmutable(path string) (*sql.DB, error) {
+	uri := url.URL{Scheme: "file", Path: path, RawQuery: "mode=ro&immutable=1"}
+	db, err := openReader(uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %s", err)
+	}
+
+	// Opening is lazy, make sure it is a readable database
+	var version int
+	err = retryBusy(context.Background(), func() error {
+		return db.QueryRow("PRAGMA schema_version").Scan(&version)
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read database: %s", err)
+	}
+
+	return db, nil
+}
+
+// Opens a copy of the places.sqlite of profileDir in the cache, which is
+// only copied again if it changed. The returned cleanup func closes it.
+func openCachedPlaces(ctx context.Context, profileDir string) (*sql.DB, func(), error) {
+	path, err := cachedCopy(ctx, profileDir, "places.sqlite")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The copy is only ever replaced, never written to
+	db, err := openImmutable(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return db, func() { db.Close() }, nil
+}
+
+// Matches the LOWER(column) GLOB LOWER(?) conditions of statements
+var globCondition = regexp.MustCompile(`LOWER\(([\w.]+)\) GLOB LOWER\(\?\)`)
+
+// Returns stmt, which matches with LOWER(...) GLOB LOWER(?), and the glob
+// pattern of query to match with it. The pattern is in NFC and case folded
+// and so is text beyond ASCII, by ffs_fold, which is too slow to call for
+// every row. ASCII text folds like it lowercases. Whether text is ASCII is
+// told apart by its length in characters and in bytes.
+func globQuery(stmt, query string) (string, string) {
+	stmt = globCondition.ReplaceAllString(stmt, "(CASE WHEN length($1) = length(CAST($1 AS BLOB)) THEN LOWER($1) ELSE ffs_fold($1) END) GLOB ?")
+
+	return stmt, foldText(convertToGlobPattern(query))
+}
+
+// Searches the history for query and calls fn for every distinct URL,
+// until ctx is done
+func searchHistory(ctx context.Context, db *sql.DB, query string, opts searchOptions, fn func(*Result) error) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	orderBy, orderArgs := opts.orderBy(query)
+	stmt, args := opts.selectColumns(), []any{}
+	if opts.FullText {
+		stmt, args = stmt+ftsFrom, append(args, norm.NFC.String(query))
+	} else {
+		if err := validateGlob(query); err != nil {
+			return err
+		}
+		from, pattern := globQuery(histFrom, query)
+		stmt, args = stmt+from, append(args, pattern, pattern, pattern)
+	}
+	args = append(args, orderArgs...)
+	stmt += histGroupBy + "\n\t\t" + orderBy
+	// Lets SQLite keep only the first rows while sorting. Excluded domains
+	// are left out as rows are read, then the limit is applied there.
+	if opts.Limit > 0 && len(opts.Exclude) == 0 {
+		stmt += "\n\t\tLIMIT ?"
+		args = append(args, opts.Limit)
+	}
+
+	// A busy database is only queried again while no results were passed on
+	passed := false
+	err := retryBusy(ctx, func() error {
+		rows, err := db.QueryContext(ctx, stmt, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		// Every URL is only returned once, so results are passed on as they are read
+		n := 0
+		for rows.Next() {
+			res, err := scanResult(rows)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error scanning row: %s\n", err)
+				continue
+			}
+			if excludedDomain(res.URL, opts.Exclude) {
+				continue
+			}
+
+			passed = true
+			if err := fn(res); err != nil {
+				return err
+			}
+			if n++; len(opts.Exclude) > 0 && n == opts.Limit {
+				break
+			}
+		}
+
+		if err := rows.Err(); err != nil && passed {
+			return fmt.Errorf("error iterating rows: %s", err)
+		}
+		return rows.Err()
+	})
+	if err != nil && !passed {
+		return queryError(ctx, db, err)
+	}
+
+	return err
+}
+
+// Reports whether the host of rawURL is one of domains or a subdomain of one
+func excludedDomain(rawURL string, domains []string) bool {
+	if len(domains) == 0 {
+		return false
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the number of distinct URLs in the history matching query
+func countHistory(ctx context.Context, db *sql.DB, query string) (int64, error) {
+	if err := validateGlob(query); err != nil {
+		return 0, err
+	}
+	stmt, pattern := globQuery(histCountQuery, query)
+
+	var count int64
+	err := retryBusy(ctx, func() error {
+		return db.QueryRowContext(ctx, stmt, pattern, pattern, pattern).Scan(&count)
+	})
+	if err != nil {
+		return 0, queryError(ctx, db, err)
+	}
+
+	return count, nil
+}
+
+// Returns the number of distinct URLs in the full-text index matching query
+func countFullText(ctx context.Context, db *sql.DB, query string) (int64, error) {
+	var count int64
+	err := retryBusy(ctx, func() error {
+		return db.QueryRowContext(ctx, ftsCountQuery, norm.NFC.String(query)).Scan(&count)
+	})
+	if err != nil {
+		return 0, queryError(ctx, db, err)
+	}
+
+	return count, nil
+}
+
+// Returns up to limit of the most recent visits of url, newest first.
+// A negative limit returns all visits.
+func recentVisits(db *sql.DB, url string, limit int) ([]time.Time, error) {
+	rows, err := db.Query(visitsQuery, url, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %s", err)
+	}
+	defer rows.Close()
+
+	var visits []time.Time
+	for rows.Next() {
+		var visitDate int64
+		if err := rows.Scan(&visitDate); err != nil {
+			return nil, fmt.Errorf("error scanning row: %s", err)
+		}
+		visits = append(visits, prTimeToTime(visitDate))
+	}
+
+	return visits, rows.Err()
+}
+
+// Returns the id of the latest visit in the history, 0 if there is none
+func lastVisitID(db *sql.DB) (int64, error) {
+	var id int64
+	if err := db.QueryRow(lastVisitIDQuery).Scan(&id); err != nil {
+		return 0, fmt.Errorf("query failed: %s", err)
+	}
+
+	return id, nil
+}
+
+// Calls fn for every visit after the visit id afterID of a page matching
+// query, oldest first. LastVisit of the results is the time of the visit.
+func newVisits(db *sql.DB, query string, afterID int64, fn func(*Result) error) error {
+	if err := validateGlob(query); err != nil {
+		return err
+	}
+	stmt, pattern := globQuery(newVisitsQuery, query)
+	rows, err := db.Query(stmt, afterID, pattern, pattern, pattern)
+	if err != nil {
+		return fmt.Errorf("query failed: %s", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var url string
+		var title, description sql.NullString
+		var visitCount, frecency, visitDate sql.NullInt64
+		if err := rows.Scan(&id, &url, &title, &description, &visitCount, &frecency, &visitDate); err != nil {
+			fmt.Fprintf(os.Stderr, "error scanning row: %s\n", err)
+			continue
+		}
+
+		res := &Result{
+			URL:         url,
+			Title:       title.String,
+			Description: description.String,
+			VisitCount:  visitCount.Int64,
+			Frecency:    frecency.Int64,
+			LastVisit:   prTimeToTime(visitDate.Int64),
+		}
+		if err := fn(res); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating rows: %s", err)
+	}
+
+	return nil
+}
+
+// Returns the page of url, nil if it is not in the history
+func lookupPage(db *sql.DB, url string) (*Result, error) {
+	rows, err := db.Query(pageQuery, url)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %s", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	res, err := scanResult(rows)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning row: %s", err)
+	}
+
+	return res, nil
+}
+
+// Returns the titles of all bookmarks of url, none if it is not bookmarked
+func bookmarkTitles(db *sql.DB, url string) ([]string, error) {
+	rows, err := db.Query(bookmarkedQuery, url)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %s", err)
+	}
+	defer rows.Close()
+
+	var titles []string
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			return nil, fmt.Errorf("error scanning row: %s", err)
+		}
+		titles = append(titles, title)
+	}
+
+	return titles, rows.Err()
+}
+
+// Searches the bookmarks for query and calls fn for every bookmark, in the
+// order they were added, until ctx is done. Only the limit and excluded
+// domains of opts apply.
+func searchBookmarks(ctx context.Context, db *sql.DB, query string, opts searchOptions, fn func(*Result) error) error {
+	if err := validateGlob(query); err != nil {
+		return err
+	}
+	stmt, pattern := globQuery(bookmarksQuery, query)
+
+	// A busy database is only queried again while no results were passed on
+	passed := false
+	err := retryBusy(ctx, func() error {
+		rows, err := db.QueryContext(ctx, stmt, pattern, pattern, pattern)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		n := 0
+		for rows.Next() {
+			var added sql.NullInt64
+			res, err := scanResult(rows, &added)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error scanning row: %s\n", err)
+				continue
+			}
+			if added.Valid {
+				res.Added = prTimeToTime(added.Int64)
+			}
+			if excludedDomain(res.URL, opts.Exclude) {
+				continue
+			}
+
+			passed = true
+			if err := fn(res); err != nil {
+				return err
+			}
+			if n++; n == opts.Limit {
+				break
+			}
+		}
+
+		if err := rows.Err(); err != nil && passed {
+			return fmt.Errorf("error iterating rows: %s", err)
+		}
+		return rows.Err()
+	})
+	if err != nil && !passed {
+		return queryError(ctx, db, err)
+	}
+
+	return err
+}
+
+// Scans the common moz_places columns of a row into a Result,
+// any extra columns following them are scanned into extra
+func scanResult(rows *sql.Rows, extra ...any) (*Result, error) {
+	var (
+		url         string
+		title       sql.NullString
+		description sql.NullString
+		visitCount  sql.NullInt64
+		frecency    sql.NullInt64
+		lastVisit   sql.NullInt64
+	)
+	dest := append([]any{&url, &title, &description, &visitCount, &frecency, &lastVisit}, extra...)
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	res := &Result{
+		URL:         url,
+		Title:       title.String,
+		Description: description.String,
+		VisitCount:  visitCount.Int64,
+		Frecency:    frecency.Int64,
+	}
+	if lastVisit.Valid {
+		res.LastVisit = prTimeToTime(lastVisit.Int64)
+	}
+
+	return res, nil
+}
+
+// Parses a duration like time.ParseDuration, additionally accepting days ("14d")
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+
+	return d, nil
+}
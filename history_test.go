@@ -0,0 +1,67 @@
+//go:build linux
+
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestGlobQueryFoldsEitherSide(t *testing.T) {
+	db, err := sql.Open(driverName, filepath.Join(t.TempDir(), "fold.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE pages (title TEXT);
+		INSERT INTO pages VALUES ('Café Straße'), ('GROSSE STRASSE'), ('Cafe'), (NULL)`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// ß in the query, ss in the text
+		{"große", []string{"GROSSE STRASSE"}},
+		// ss in the query, ß in the text
+		{"strasse", []string{"Café Straße", "GROSSE STRASSE"}},
+		{"STRASSE", []string{"Café Straße", "GROSSE STRASSE"}},
+		{"straße", []string{"Café Straße", "GROSSE STRASSE"}},
+		{"STRAßE", []string{"Café Straße", "GROSSE STRASSE"}},
+		{"aße", []string{"Café Straße", "GROSSE STRASSE"}},
+		{"café", []string{"Café Straße"}},
+		{"cafe", []string{"Cafe"}},
+	}
+
+	for _, tt := range tests {
+		stmt, pattern := globQuery(`SELECT title FROM pages WHERE LOWER(title) GLOB LOWER(?) ORDER BY rowid`, tt.query)
+		rows, err := db.Query(stmt, pattern)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.query, err)
+		}
+
+		var got []string
+		for rows.Next() {
+			var title string
+			if err := rows.Scan(&title); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, title)
+		}
+		rows.Close()
+
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.query, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %q, want %q", tt.query, got, tt.want)
+				break
+			}
+		}
+	}
+}
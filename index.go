@@ -0,0 +1,266 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// The version of the text in the index, it is built again when it changes
+const indexVersion = 2
+
+const (
+	// The full-text index ffs keeps per profile in its snapshotCacheDir.
+	// Its tables are named like and hold the columns ffs reads of
+	// places.sqlite, so it is searched like the history itself.
+	indexSchema = `
+		CREATE TABLE IF NOT EXISTS moz_places (
+			id INTEGER PRIMARY KEY,
+			url TEXT,
+			title TEXT,
+			description TEXT,
+			visit_count INTEGER,
+			frecency INTEGER,
+			last_visit_date INTEGER
+		);
+		CREATE INDEX IF NOT EXISTS ffs_places_url ON moz_places(url);
+		CREATE TABLE IF NOT EXISTS moz_historyvisits (
+			id INTEGER PRIMARY KEY,
+			place_id INTEGER,
+			visit_date INTEGER
+		);
+		CREATE INDEX IF NOT EXISTS moz_historyvisits_placedateindex ON moz_historyvisits(place_id, visit_date);
+		CREATE VIRTUAL TABLE IF NOT EXISTS ffs_fts USING ` + ftsModule + `(url, title, description, tokenize=unicode61);
+		CREATE TABLE IF NOT EXISTS ffs_meta (
+			key TEXT PRIMARY KEY,
+			value INTEGER
+		);`
+	// The SQL query to count all visits
+	visitCountQuery = `
+		SELECT COUNT(*) FROM moz_historyvisits`
+	// The SQL query to count the visits after a visit id
+	newVisitCountQuery = `
+		SELECT COUNT(*) FROM moz_historyvisits WHERE id > ?`
+	// The SQL query to get the visits after a visit id
+	indexVisitsQuery = `
+		SELECT id, place_id, visit_date FROM moz_historyvisits WHERE id > ?`
+	// The SQL query to get the pages visited after a visit id
+	indexPlacesQuery = `
+		SELECT id, url, title, description, visit_count, frecency, last_visit_date
+		FROM moz_places
+		WHERE id IN (SELECT place_id FROM moz_historyvisits WHERE id > ?)`
+)
+
+// Opens the full-text index of profileDir, updating it with the visits
+// since its last update first, until ctx is done. The returned cleanup
+// func closes it.
+func openIndex(ctx context.Context, profileDir string) (*sql.DB, func(), error) {
+	path, err := indexPath(profileDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	places, closePlaces, err := openPlaces(ctx, profileDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer closePlaces()
+
+	if err := updateIndex(ctx, path, places); err != nil {
+		return nil, nil, err
+	}
+
+	db, err := sql.Open(driverName, path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open index: %s", err)
+	}
+
+	return db, func() { db.Close() }, nil
+}
+
+// Returns the path of the full-text index of profileDir, which is created
+// empty if there is none
+func indexPath(profileDir string) (string, error) {
+	dir, err := makeSnapshotCacheDir(profileDir)
+	if err != nil {
+		return "", err
+	}
+	// Builds with and without cgo have different full-text modules
+	path := filepath.Join(dir, "index-"+ftsModule+".sqlite")
+
+	// SQLite would create it readable by everyone, its journals take over
+	// the permissions of the database
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create index: %s", err)
+	}
+	f.Close()
+
+	return path, nil
+}
+
+// Brings the index at path up to date with places. Only the visits since
+// the last update and their pages are added, unless visits were removed
+// since, then the index is built again.
+func updateIndex(ctx context.Context, path string, places *sql.DB) error {
+	// Transactions that start reading fail instead of waiting when another
+	// run wrote in between, so they take the write lock right away
+	index, err := sql.Open(driverName, path+"?_txlock=immediate")
+	if err != nil {
+		return fmt.Errorf("failed to open index: %s", err)
+	}
+	defer index.Close()
+
+	// A single connection, so the pragmas apply to every statement
+	index.SetMaxOpenConns(1)
+	// Other runs may be updating the index as well, they take turns. In WAL
+	// mode, searches are not blocked by updates.
+	for _, stmt := range []string{"PRAGMA busy_timeout = 10000", "PRAGMA journal_mode = WAL", indexSchema} {
+		if _, err := index.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create index: %s", err)
+		}
+	}
+
+	tx, err := index.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to update index: %s", err)
+	}
+	defer tx.Rollback()
+
+	var lastID, visits, version int64
+	tx.QueryRow("SELECT value FROM ffs_meta WHERE key = 'last_visit_id'").Scan(&lastID)
+	tx.QueryRow("SELECT value FROM ffs_meta WHERE key = 'visits'").Scan(&visits)
+	tx.QueryRow("SELECT value FROM ffs_meta WHERE key = 'version'").Scan(&version)
+
+	srcLastID, err := lastVisitID(places)
+	if err != nil {
+		return err
+	}
+	var srcVisits, newVisits int64
+	if err := places.QueryRowContext(ctx, visitCountQuery).Scan(&srcVisits); err != nil {
+		return fmt.Errorf("query failed: %s", err)
+	}
+	if srcLastID == lastID && srcVisits == visits && version == indexVersion {
+		return nil
+	}
+	if err := places.QueryRowContext(ctx, newVisitCountQuery, lastID).Scan(&newVisits); err != nil {
+		return fmt.Errorf("query failed: %s", err)
+	}
+
+	// Visits are only ever added, unless the history was cleared
+	if srcLastID < lastID || visits+newVisits != srcVisits || version != indexVersion {
+		for _, table := range []string{"moz_places", "moz_historyvisits", "ffs_fts"} {
+			if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+				return fmt.Errorf("failed to update index: %s", err)
+			}
+		}
+		lastID = 0
+	}
+
+	if err := copyIndexVisits(ctx, tx, places, lastID); err != nil {
+		return err
+	}
+	if err := copyIndexPlaces(ctx, tx, places, lastID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("INSERT OR REPLACE INTO ffs_meta (key, value) VALUES ('last_visit_id', ?), ('visits', ?), ('version', ?)", srcLastID, srcVisits, indexVersion); err != nil {
+		return fmt.Errorf("failed to update index: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to update index: %s", err)
+	}
+
+	return nil
+}
+
+// Copies the visits after the visit id afterID from places into the index
+func copyIndexVisits(ctx context.Context, tx *sql.Tx, places *sql.DB, afterID int64) error {
+	rows, err := places.QueryContext(ctx, indexVisitsQuery, afterID)
+	if err != nil {
+		return fmt.Errorf("query failed: %s", err)
+	}
+	defer rows.Close()
+
+	insert, err := tx.Prepare("INSERT OR REPLACE INTO moz_historyvisits (id, place_id, visit_date) VALUES (?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to update index: %s", err)
+	}
+	defer insert.Close()
+
+	for rows.Next() {
+		var id, placeID, visitDate sql.NullInt64
+		if err := rows.Scan(&id, &placeID, &visitDate); err != nil {
+			return fmt.Errorf("error scanning row: %s", err)
+		}
+		if _, err := insert.Exec(id, placeID, visitDate); err != nil {
+			return fmt.Errorf("failed to update index: %s", err)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating rows: %s", err)
+	}
+
+	return nil
+}
+
+// Copies the pages visited after the visit id afterID from places into the
+// index, replacing their previous titles and counts
+func copyIndexPlaces(ctx context.Context, tx *sql.Tx, places *sql.DB, afterID int64) error {
+	rows, err := places.QueryContext(ctx, indexPlacesQuery, afterID)
+	if err != nil {
+		return fmt.Errorf("query failed: %s", err)
+	}
+	defer rows.Close()
+
+	var stmts []*sql.Stmt
+	for _, query := range []string{
+		"DELETE FROM ffs_fts WHERE rowid = ?",
+		"INSERT OR REPLACE INTO moz_places (id, url, title, description, visit_count, frecency, last_visit_date) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		"INSERT INTO ffs_fts (rowid, url, title, description) VALUES (?, ?, ?, ?)",
+	} {
+		stmt, err := tx.Prepare(query)
+		if err != nil {
+			return fmt.Errorf("failed to update index: %s", err)
+		}
+		defer stmt.Close()
+		stmts = append(stmts, stmt)
+	}
+	deleteText, insertPlace, insertText := stmts[0], stmts[1], stmts[2]
+
+	for rows.Next() {
+		var (
+			id                              int64
+			url                             string
+			title, description              sql.NullString
+			visitCount, frecency, lastVisit sql.NullInt64
+		)
+		if err := rows.Scan(&id, &url, &title, &description, &visitCount, &frecency, &lastVisit); err != nil {
+			return fmt.Errorf("error scanning row: %s", err)
+		}
+
+		if _, err := deleteText.Exec(id); err != nil {
+			return fmt.Errorf("failed to update index: %s", err)
+		}
+		if _, err := insertPlace.Exec(id, url, title, description, visitCount, frecency, lastVisit); err != nil {
+			return fmt.Errorf("failed to update index: %s", err)
+		}
+		// Full-text queries are in NFC as well, the tokenizer folds case
+		if _, err := insertText.Exec(id, norm.NFC.String(url), norm.NFC.String(title.String), norm.NFC.String(description.String)); err != nil {
+			return fmt.Errorf("failed to update index: %s", err)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating rows: %s", err)
+	}
+
+	return nil
+}
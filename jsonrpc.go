@@ -0,0 +1,114 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Error codes defined by JSON-RPC 2.0
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+// A JSON-RPC 2.0 request, or a notification if ID is empty
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// A JSON-RPC 2.0 response
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// A JSON-RPC 2.0 error, also returned by handlers to choose the code
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// Answers a method call, the result is encoded as JSON
+type rpcHandler func(method string, params json.RawMessage) (any, error)
+
+// Reads newline-delimited JSON-RPC 2.0 requests from r and writes the
+// responses of handle to w, until r is closed. Notifications are handled
+// but not answered.
+func serveJSONRPC(r io.Reader, w io.Writer, handle rpcHandler) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	enc := json.NewEncoder(w)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var req rpcRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			resp := rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{rpcParseError, err.Error()}}
+			if err := enc.Encode(resp); err != nil {
+				return err
+			}
+			continue
+		}
+
+		resp := rpcResponse{JSONRPC: "2.0", ID: req.ID}
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			resp.Error = &rpcError{rpcInvalidRequest, "invalid request"}
+		} else {
+			result, err := handle(req.Method, req.Params)
+			if err != nil {
+				rerr, ok := err.(*rpcError)
+				if !ok {
+					rerr = &rpcError{rpcInternalError, err.Error()}
+				}
+				resp.Error = rerr
+			} else {
+				if result == nil {
+					result = struct{}{}
+				}
+				resp.Result = result
+			}
+		}
+
+		if len(req.ID) == 0 {
+			continue
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Decodes params into v, failing with an invalid params error
+func decodeParams(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{rpcInvalidParams, fmt.Sprintf("invalid params: %s", err)}
+	}
+
+	return nil
+}
@@ -0,0 +1,232 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	// Where the runner is found on the session bus
+	krunnerService = "io.github.rtfmkiesel.ffs"
+	krunnerPath    = "/ffs"
+	krunnerIface   = "org.kde.krunner1"
+	// How many matches are returned to KRunner
+	krunnerLimit = 10
+	// KRunner queries shorter than this are ignored
+	krunnerMinQuery = 3
+	// How long a snapshot of the history is reused between queries
+	krunnerSnapshotAge = 30 * time.Second
+	// KRunner's QueryMatch::PossibleMatch
+	krunnerPossibleMatch = 30
+)
+
+// The plugin metadata KRunner loads D-Bus runners from
+const krunnerDesktopFile = `[Desktop Entry]
+Name=Firefox history
+Comment=Search the Firefox history with ffs
+Type=Service
+Icon=firefox
+X-KDE-ServiceTypes=Plasma/Runner
+X-KDE-PluginInfo-Name=ffs
+X-KDE-PluginInfo-EnabledByDefault=true
+X-Plasma-API=DBus
+X-Plasma-DBusRunner-Service=` + krunnerService + `
+X-Plasma-DBusRunner-Path=` + krunnerPath + `
+`
+
+// A match as returned by org.kde.krunner1.Match, (sssida{sv})
+type krunnerMatch struct {
+	ID         string
+	Text       string
+	Icon       string
+	Type       int32
+	Relevance  float64
+	Properties map[string]dbus.Variant
+}
+
+// An action shown next to matches, (sss)
+type krunnerAction struct {
+	ID   string
+	Text string
+	Icon string
+}
+
+// Implements the org.kde.krunner1 interface over a snapshot of the history
+// that is refreshed once it is older than krunnerSnapshotAge
+type krunnerRunner struct {
+	places  *placesSnapshot
+	browser string
+}
+
+// The flags of the krunner subcommand
+var krunnerFlags struct {
+	install bool
+	browser string
+}
+
+// Returns the flag set of the krunner subcommand
+func krunnerFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("krunner", flag.ExitOnError)
+	profileFlag(fs)
+	fs.BoolVar(&krunnerFlags.install, "install", false, "install the KRunner plugin metadata and exit")
+	fs.StringVar(&krunnerFlags.browser, "browser", "", "`command` to open URLs with, %s is replaced by the URL (default $BROWSER or xdg-open)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: ffs krunner [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Runs the krunner subcommand, serving history matches to KRunner until
+// the session ends
+func runKRunner(args []string) {
+	if _, err := parseArgs(krunnerFlagSet(), args); err != nil {
+		os.Exit(exitError)
+	}
+
+	if krunnerFlags.install {
+		path, err := installKRunnerPlugin()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitError)
+		}
+		fmt.Println(path)
+		return
+	}
+
+	profileDir, err := getFirefoxProfileDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get Mozilla profile directory: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not connect to the session bus: %s\n", err)
+		os.Exit(exitError)
+	}
+	defer conn.Close()
+
+	r := &krunnerRunner{
+		places:  newPlacesSnapshot(profileDir, krunnerSnapshotAge),
+		browser: browserCommand(krunnerFlags.browser),
+	}
+	defer r.places.Close()
+
+	if err := conn.Export(r, krunnerPath, krunnerIface); err != nil {
+		fmt.Fprintf(os.Stderr, "could not export the runner: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	reply, err := conn.RequestName(krunnerService, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not register %s: %s\n", krunnerService, err)
+		os.Exit(exitError)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		fmt.Fprintf(os.Stderr, "%s is already registered, is ffs krunner running?\n", krunnerService)
+		os.Exit(exitError)
+	}
+
+	// Serve until stopped, then remove the snapshot
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+}
+
+// Writes the plugin metadata into the user's KRunner plugin directory
+// and returns its path
+func installKRunnerPlugin() (string, error) {
+	dataDir := os.Getenv("XDG_DATA_HOME")
+	if dataDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("could not get home directory: %s", err)
+		}
+		dataDir = filepath.Join(homeDir, ".local", "share")
+	}
+
+	dir := filepath.Join(dataDir, "krunner", "dbusplugins")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("could not create %s: %s", dir, err)
+	}
+
+	path := filepath.Join(dir, "ffs.desktop")
+	if err := os.WriteFile(path, []byte(krunnerDesktopFile), 0644); err != nil {
+		return "", fmt.Errorf("could not write %s: %s", path, err)
+	}
+
+	return path, nil
+}
+
+// Returns the history matching query, best first
+func (r *krunnerRunner) Match(query string) ([]krunnerMatch, *dbus.Error) {
+	matches := []krunnerMatch{}
+	if len([]rune(query)) < krunnerMinQuery {
+		return matches, nil
+	}
+
+	opts := defaultSearchOptions
+	opts.Sort = "relevance"
+	opts.Limit = krunnerLimit
+	err := r.places.With(func(db *sql.DB) error {
+		return searchHistory(context.Background(), db, query, opts, func(res *Result) error {
+			text := strings.Join(strings.Fields(res.Title), " ")
+			if text == "" {
+				text = res.URL
+			}
+
+			// KRunner sorts by relevance, keep the order of ffs
+			matches = append(matches, krunnerMatch{
+				ID:        res.URL,
+				Text:      text,
+				Icon:      "internet-web-browser",
+				Type:      krunnerPossibleMatch,
+				Relevance: 1 - float64(len(matches))/krunnerLimit/2,
+				Properties: map[string]dbus.Variant{
+					"subtext": dbus.MakeVariant(res.URL),
+					"urls":    dbus.MakeVariant([]string{res.URL}),
+				},
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, dbus.MakeFailedError(err)
+	}
+
+	return matches, nil
+}
+
+// Returns the actions shown next to matches
+func (r *krunnerRunner) Actions() ([]krunnerAction, *dbus.Error) {
+	return []krunnerAction{{ID: "copy", Text: "Copy URL", Icon: "edit-copy"}}, nil
+}
+
+// Opens the URL of a match, or runs one of its actions
+func (r *krunnerRunner) Run(matchID, actionID string) *dbus.Error {
+	var err error
+	switch actionID {
+	case "copy":
+		err = copyToClipboard(matchID)
+	default:
+		err = openURL(r.browser, matchID)
+	}
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+
+	return nil
+}
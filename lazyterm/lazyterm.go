@@ -0,0 +1,24 @@
+//go:build linux
+
+// Package lazyterm keeps Lip Gloss from querying the terminal whenever ffs
+// starts. Bubble Tea asks Lip Gloss for the background color in an init
+// function, which writes an OSC 11 query to stdout if it is a terminal and
+// waits up to five seconds for the answer, even if the TUI is never shown.
+//
+// Go initializes packages in the order of their import path once their
+// imports are initialized, so this one runs right after termenv and before
+// Lip Gloss takes termenv's default output for its default renderer. The
+// TUI sets up a renderer on its terminal once it starts.
+package lazyterm
+
+import (
+	"io"
+	"os"
+
+	"github.com/muesli/termenv"
+)
+
+func init() {
+	// Only an *os.File is taken for a terminal, and only a terminal is queried
+	termenv.SetDefaultOutput(termenv.NewOutput(struct{ io.Writer }{os.Stdout}))
+}
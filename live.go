@@ -0,0 +1,202 @@
+//go:build linux
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How often an idle live search is sent a comment, so proxies keep it open
+const liveHeartbeat = 30 * time.Second
+
+// Pushes new visits to the live searches of a server. The profile is only
+// watched once the first live search started.
+type liveHub struct {
+	places *placesSnapshot
+
+	start sync.Once
+	mu    sync.Mutex
+	subs  map[*liveSub]bool
+}
+
+// A live search waiting for new visits
+type liveSub struct {
+	query string
+	// The id of the latest visit the search has seen
+	last   int64
+	visits chan *Result
+}
+
+func newLiveHub(places *placesSnapshot) *liveHub {
+	return &liveHub{places: places, subs: make(map[*liveSub]bool)}
+}
+
+// Starts watching the profile, once
+func (h *liveHub) watch() error {
+	var err error
+	h.start.Do(func() {
+		var changes <-chan struct{}
+		changes, err = watchPlaces(h.places.profileDir)
+		if err != nil {
+			return
+		}
+
+		go func() {
+			settle := time.NewTimer(watchSettle)
+			settle.Stop()
+			for {
+				select {
+				case <-changes:
+					settle.Reset(watchSettle)
+				case <-settle.C:
+					h.poll()
+				}
+			}
+		}()
+	})
+
+	return err
+}
+
+// Takes a new snapshot and sends the new visits to the live searches they match
+func (h *liveHub) poll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.subs) == 0 {
+		return
+	}
+
+	h.places.Invalidate()
+	h.places.With(func(db *sql.DB) error {
+		last, err := lastVisitID(db)
+		if err != nil {
+			return err
+		}
+
+		for sub := range h.subs {
+			newVisits(db, sub.query, sub.last, func(res *Result) error {
+				select {
+				case sub.visits <- res:
+				default:
+					// The client does not keep up, it misses the visit
+				}
+				return nil
+			})
+			sub.last = last
+		}
+
+		return nil
+	})
+}
+
+func (h *liveHub) subscribe(sub *liveSub) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.subs[sub] = true
+}
+
+func (h *liveHub) unsubscribe(sub *liveSub) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subs, sub)
+}
+
+// Streams a search as server-sent events: the results as "result" events
+// while they are read, a "done" event with their number, and then a
+// "visit" event for every new visit of a matching page until the client
+// disconnects. Takes the parameters of /search except format.
+func (s *server) handleLive(w http.ResponseWriter, r *http.Request) {
+	query, opts, offset, err := parseSearchParams(r.URL.Query())
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	if err := s.live.watch(); err != nil {
+		httpError(w, http.StatusInternalServerError, fmt.Sprintf("failed to watch profile: %s", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	// Results are sent while the snapshot is searched, which is not locked
+	// meanwhile. A client that stops reading is given up on.
+	out := deadlineWriter{w, http.NewResponseController(w).SetWriteDeadline, serveWriteTimeout}
+	send := func(event string, data any) error {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(out, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	start := time.Now()
+	sub := &liveSub{query: query, visits: make(chan *Result, 64)}
+	sent := 0
+	err = s.places.With(func(db *sql.DB) error {
+		skipped := 0
+		err := searchHistory(r.Context(), db, query, opts, func(res *Result) error {
+			if skipped < offset {
+				skipped++
+				return nil
+			}
+			sent++
+			return send("result", newJSONResult(res))
+		})
+		if err != nil {
+			return err
+		}
+
+		// Visits after this snapshot are pushed
+		sub.last, err = lastVisitID(db)
+		return err
+	})
+	metrics.observeQuery("live", start, sent, err)
+	if err != nil {
+		send("error", map[string]string{"error": err.Error()})
+		return
+	}
+	if err := send("done", map[string]int{"count": sent}); err != nil {
+		return
+	}
+
+	s.live.subscribe(sub)
+	defer s.live.unsubscribe(sub)
+
+	heartbeat := time.NewTicker(liveHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case res := <-sub.visits:
+			if err := send("visit", newJSONResult(res)); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(out, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -3,159 +3,1118 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
-	"regexp"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
+)
+
+// Where ffs lives, used as the link of generated feeds
+const projectURL = "https://github.com/rtfmkiesel/ffs"
 
-	_ "github.com/mattn/go-sqlite3"
+// Exit codes like grep's, of every subcommand
+const (
+	// Nothing matched or nothing was picked
+	exitNoMatch = 1
+	exitError   = 2
 )
 
+// How many pages are listed when no query is given
+const defaultRecentLimit = 20
+
 var (
-	// To find the default profile in profiles.ini
-	reDefaultProfile = regexp.MustCompile(`\[Install.*\]`)
+	flagCSV      = flag.Bool("csv", false, "print results as CSV")
+	flagTSV      = flag.Bool("tsv", false, "print results as tab-separated values")
+	flagNoHeader = flag.Bool("no-header", false, "omit the header row in CSV/TSV output")
+	flagMarkdown = flag.Bool("markdown", false, "print results as Markdown")
+	flagMDStyle  = flag.String("markdown-style", "list", "Markdown output style, \"list\" of links or \"table\"")
+	flagHTML     = flag.Bool("html", false, "print results as a self-contained HTML report")
+	flagYAML     = flag.Bool("yaml", false, "print results as YAML")
+	flagRSS      = flag.Bool("rss", false, "print results as an RSS feed")
+	flagFormat   = flag.String("format", "", "print each result using a Go `template`, e.g. '{{.Title}}\\t{{.URL}}', or every visit as JSON for \"promnesia\"")
+	flagTable    = flag.Bool("table", false, "print results as an aligned table")
+	flagNoTrunc  = flag.Bool("no-truncate", false, "do not truncate table columns to the terminal width")
+	flagOrg      = flag.Bool("org", false, "print results as Org mode entries")
+	flagExportDB = flag.String("export-sqlite", "", "write results into a new SQLite database at `path`")
+	flagColumns  = flag.String("columns", "", "comma separated columns to print and their order, out of "+strings.Join(allColumns, ","))
+	flagWithDate = flag.Bool("with-date", false, "also print the last visit date")
+	flagDateFmt  = flag.String("date-format", "rfc3339", "date format: rfc3339 (local time) or relative")
+	flagColor    = flag.String("color", "auto", "highlight matches: auto, always or never")
+	flagGroupBy  = flag.String("group-by", "", "group results, currently only by \"domain\"")
+	flagCompress = flag.String("compress", "", "compress the output with gzip or zstd")
+	flagSort     = flag.String("sort", "", "sort results by relevance (default on a terminal), date (newest first, default otherwise), frecency, visits (most first), hot (visits decayed by age), url or title")
+	flagReverse  = flag.Bool("reverse", false, "reverse the sort order")
+	flagHalfLife = flag.String("half-life", "7d", "`duration` after which a visit counts half for --sort hot, e.g. 12h or 14d")
+	flagWeights  = flag.String("relevance-weights", "1,1,1", "`match,frecency,recency` weights for --sort relevance")
+	flagLimit    = flag.Int("limit", 0, "print at most `n` results, 0 for all (without a query: the 20 most recent)")
+	flagSummary  = flag.Bool("summary", false, "print the number of matches and elapsed time to stderr")
+	flagPrint0   bool
+	// Set with --exclude-domain
+	excludedDomains []string
+	flagCount       bool
+	flagQuiet       bool
+	flagOutput      string
+	flagInteract    bool
+	flagRofi        = flag.Bool("rofi", false, "print results as rofi rows, also works as a rofi script mode")
+	flagRofiIcon    = flag.Bool("rofi-icons", false, "show favicons in rofi rows")
+	flagResolve     = flag.Bool("rofi-resolve", false, "read a row index selected in rofi -dmenu -format i from stdin and print its URL")
+	flagOpen        = flag.Bool("open", false, "open the first result, or the one picked with -i/--tui, in the browser")
+	flagOpenAll     = flag.Bool("open-all", false, "open all results in the browser")
+	flagBrowser     = flag.String("browser", "", "`command` to open URLs with, %s is replaced by the URL (default $BROWSER or xdg-open)")
+	flagWindow      = flag.Bool("window", false, "with --open-all, open the results as tabs of a new Firefox window (--browser sets the Firefox command)")
+	flagCopy        = flag.Bool("copy", false, "copy the first result, or the one picked with -i/--tui, to the clipboard")
+	flagQR          = flag.Bool("qr", false, "show the first result, or the one picked with -i/--tui, as a QR code")
+	flagLauncher    = flag.String("launcher", "", "print results for desktop launcher plugins (Ulauncher, Albert), currently only \"json\"")
+	flagMenu        = flag.Bool("menu", false, "pick a result with a menu like fuzzel, wofi or bemenu and open it")
+	flagMenuCmd     = flag.String("menu-command", "", "dmenu-like `command` for --menu (default: the first of fuzzel, wofi and bemenu found)")
+	flagGrep        = flag.Bool("grep-format", false, "print results as grep-like profile:title:url lines")
+	flagNull        = flag.Bool("null", false, "separate the --grep-format fields with NUL instead of colons")
+	flagPager       = flag.Bool("pager", false, "always show results on a terminal in $PAGER (default: only if they do not fit)")
+	flagNoPager     = flag.Bool("no-pager", false, "never show results in a pager")
+	flagTUI         = flag.Bool("tui", false, "browse results in a full screen TUI with a preview pane and print the picked URL")
+	flagNoDaemon    = flag.Bool("no-daemon", false, "always read a new snapshot of the history, even if ffs daemon is running")
+	flagTimeout     = flag.Duration("timeout", 0, "give up on a search that takes longer than `duration`, e.g. 30s (default no limit)")
+	flagFTS         = flag.Bool("fts", false, "the query is a full-text query (words, \"phrases\", prefix*, OR, NOT) of an index kept in $XDG_CACHE_HOME/ffs")
 )
 
-const (
-	// Where the db is copied to temporarily
-	dbTmpPath = "/tmp/places.sqlite"
-	// The SQL query to get the history filtered by the argument as a glob pattern
-	histQuery = `
-		SELECT DISTINCT url
-		FROM moz_places
-		JOIN moz_historyvisits ON moz_places.id = moz_historyvisits.place_id
-		WHERE LOWER(url) GLOB LOWER(?) OR LOWER(title) GLOB LOWER(?) OR LOWER(description) GLOB LOWER(?)
-		ORDER BY last_visit_date ASC`
-)
+func init() {
+	flag.StringVar(&selectedInstall, "install", "", "use the default profile of the Firefox installation with the `hash` of its [Install<hash>] section in profiles.ini (default: of the firefox in $PATH)")
+	profileFlag(flag.CommandLine)
+	flag.Func("pragma", "set the SQLite `pragma=value` to read the history with, one of "+strings.Join(tunablePragmas, ", ")+" (repeatable)", func(s string) error {
+		p, err := parsePragma(s)
+		if err != nil {
+			return err
+		}
+		readerPragmas = append(readerPragmas, p)
+		return nil
+	})
+	flag.Func("exclude-domain", "leave out pages of `domain` and its subdomains (repeatable)", func(s string) error {
+		d := strings.ToLower(strings.Trim(strings.TrimSpace(s), "."))
+		if d == "" {
+			return fmt.Errorf("empty domain")
+		}
+		excludedDomains = append(excludedDomains, d)
+		return nil
+	})
+	flag.BoolVar(&flagInteract, "interactive", false, "pick a result with a fuzzy finder (fzf if installed) and print its URL")
+	flag.BoolVar(&flagInteract, "i", false, "shorthand for --interactive")
+	flag.StringVar(&flagOutput, "output", "", "write results to `file` instead of stdout (\"-\" for stdout)")
+	flag.StringVar(&flagOutput, "o", "", "shorthand for --output")
+	flag.BoolVar(&flagQuiet, "quiet", false, "only print URLs, even on a terminal")
+	flag.BoolVar(&flagQuiet, "q", false, "shorthand for --quiet")
+	flag.BoolVar(&flagCount, "count", false, "only print the number of matching URLs")
+	flag.BoolVar(&flagCount, "c", false, "shorthand for --count")
+	flag.BoolVar(&flagPrint0, "print0", false, "terminate each URL with NUL instead of newline")
+	flag.BoolVar(&flagPrint0, "0", false, "shorthand for --print0")
+}
+
+// A subcommand of ffs, run if it is the first argument
+type subcommand struct {
+	name string
+	// Shown by the shell completions
+	description string
+	run         func(args []string)
+	// Returns the flags it parses with parseArgs, nil if it takes none
+	flags func() *flag.FlagSet
+	// What its first positional argument is completed with
+	args []string
+}
+
+// The subcommands of ffs, set in init as ffs completion lists them
+var subcommands []subcommand
+
+func init() {
+	subcommands = []subcommand{
+		{name: "search", description: "search the history, what ffs does without a command", run: runSearch, flags: searchFlagSet},
+		{name: "bookmarks", description: "search the bookmarks, in the order they were added", run: runBookmarks, flags: bookmarksFlagSet},
+		{name: "profiles", description: "list the Firefox profiles, * marks the one searched", run: runProfiles, flags: profilesFlagSet},
+		{name: "export", description: "export matching history or bookmarks as a bookmark file", run: runExport, flags: exportFlagSet},
+		{name: "open", description: "open a result of the last search", run: runOpen, flags: openFlagSet},
+		{name: "repl", description: "search one snapshot of the history, one query per line", run: runREPL, flags: replFlagSet},
+		{name: "watch", description: "print new visits as they happen", run: runWatch, flags: watchFlagSet},
+		{name: "daemon", description: "keep a snapshot of the history for faster searches", run: runDaemon, flags: daemonFlagSet},
+		{name: "systemd-install", description: "install systemd user units starting ffs daemon", run: runSystemdInstall, flags: systemdInstallFlagSet},
+		{name: "rpc", description: "answer JSON-RPC requests of editor plugins on stdio", run: runRPC, flags: rpcFlagSet},
+		{name: "mcp", description: "serve the history to MCP clients on stdio", run: runMCP, flags: mcpFlagSet},
+		{name: "serve", description: "answer history searches over HTTP", run: runServe, flags: serveFlagSet},
+		{name: "tmux", description: "pick a result in a tmux popup and paste it", run: runTmux, flags: tmuxFlagSet},
+		{name: "krunner", description: "serve history matches to KRunner", run: runKRunner, flags: krunnerFlagSet},
+		{name: "widget", description: "print a shell key binding picking a URL", run: runWidget, args: completionShells},
+		{name: "native-host", description: "answer the browser extension, run by Firefox", run: runNativeHost},
+		{name: "bench", description: "time the phases of a search", run: runBench, flags: benchFlagSet},
+		{name: "install-native-host", description: "register the native messaging host", run: runInstallNativeHost, flags: installNativeHostFlagSet},
+		{name: "completion", description: "print a shell completion script", run: runCompletion, args: completionShells},
+		{name: "help", description: "show how ffs or a command is used", run: runHelp},
+	}
+}
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "usage: ffs \"<query>\"\n")
-		os.Exit(1)
+	// Without a command, ffs searches the history like ffs search
+	if len(os.Args) > 1 {
+		for _, cmd := range subcommands {
+			if os.Args[1] == cmd.name {
+				cmd.run(os.Args[2:])
+				return
+			}
+		}
+	}
+
+	runSearch(os.Args[1:])
+}
+
+// Runs the search subcommand, searching the history for a query
+func runSearch(args []string) {
+	runQuery(args, false)
+}
+
+// Runs the bookmarks subcommand, searching the bookmarks for a query
+func runBookmarks(args []string) {
+	runQuery(args, true)
+}
+
+// Prints how ffs is used, with its subcommands and the flags of ffs search
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: ffs [search] [flags] [\"<query>\"]\n       ffs <command> [flags] [args]\n\ncommands:\n")
+	width := 0
+	for _, cmd := range subcommands {
+		width = max(width, len(cmd.name))
+	}
+	for _, cmd := range subcommands {
+		fmt.Fprintf(os.Stderr, "  %-*s  %s\n", width, cmd.name, cmd.description)
+	}
+	fmt.Fprintf(os.Stderr, "\nffs help <command> shows the flags of a command, those of ffs search and ffs bookmarks are:\n\n")
+	flag.PrintDefaults()
+}
+
+// Runs the help subcommand, printing how ffs or a subcommand is used
+func runHelp(args []string) {
+	if len(args) == 0 {
+		usage()
+		return
+	}
+
+	for _, cmd := range subcommands {
+		if cmd.name != args[0] {
+			continue
+		}
+		if cmd.flags == nil {
+			fmt.Fprintf(os.Stderr, "usage: ffs %s %s\n\n%s\n", cmd.name, strings.Join(cmd.args, "|"), cmd.description)
+			return
+		}
+		cmd.flags().Usage()
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q (available: %s)\n", args[0], strings.Join(subcommandNames(), ", "))
+	os.Exit(exitError)
+}
+
+// Returns the flag set of ffs itself, that of the search and bookmarks
+// subcommands, named like the one run so config.toml applies its section
+func rootFlagSet(name string) *flag.FlagSet {
+	flag.CommandLine.Init(name, flag.ExitOnError)
+	flag.Usage = usage
+	return flag.CommandLine
+}
+
+// Returns the flag set of the search subcommand
+func searchFlagSet() *flag.FlagSet {
+	return rootFlagSet("search")
+}
+
+// Returns the flag set of the bookmarks subcommand
+func bookmarksFlagSet() *flag.FlagSet {
+	return rootFlagSet("bookmarks")
+}
+
+// Searches the history, or the bookmarks, for the query in args with the
+// flags of ffs itself and prints the results
+func runQuery(args []string, bookmarks bool) {
+	start := time.Now()
+
+	fs := searchFlagSet()
+	if bookmarks {
+		fs = bookmarksFlagSet()
+	}
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		os.Exit(exitError)
+	}
+
+	// In rofi script mode, rofi runs ffs again once a row was selected
+	if *flagRofi && os.Getenv("ROFI_RETV") == "1" {
+		if url := os.Getenv("ROFI_INFO"); url != "" {
+			if err := openURL(browserCommand(*flagBrowser), url); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(exitError)
+			}
+		}
+		return
+	}
+
+	// The interactive modes filter themselves, so the query is optional.
+	// Otherwise, no query lists the most recently visited pages.
+	recent := false
+	if len(args) == 0 {
+		args = []string{"*"}
+		recent = !bookmarks && !flagInteract && !*flagTUI && !*flagMenu
+	}
+
+	if args[0] == "" {
+		flag.Usage()
+		os.Exit(exitError)
+	}
+	query := args[0]
+
+	if *flagFTS && bookmarks {
+		fmt.Fprintf(os.Stderr, "--fts only searches the history\n")
+		os.Exit(exitError)
+	}
+	// Everything matches a pattern of *, but there is no full-text query for it
+	if *flagFTS && recent {
+		fmt.Fprintf(os.Stderr, "--fts needs a query\n")
+		os.Exit(exitError)
+	}
+	if !*flagFTS {
+		if err := validateGlob(query); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitError)
+		}
+	}
+
+	format, err := outputFormat()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitCode(exitError))
+	}
+
+	// Whether results are shown to a human
+	interactive := isStdout(flagOutput) && isTerminal(os.Stdout)
+
+	if *flagCompress != "" && interactive {
+		fmt.Fprintf(os.Stderr, "compressed data not written to a terminal, use --output or a redirect\n")
+		os.Exit(exitCode(exitError))
+	}
+
+	// Plain output defaults to URL and title on a terminal and bare URLs
+	// otherwise, Markdown lists to links and the other formats to defaultColumns
+	columns := defaultColumns
+	switch {
+	case format == "plain" && interactive && !flagQuiet:
+		columns = []string{"url", "title"}
+	case format == "plain":
+		columns = []string{"url"}
+	case format == "markdown" && *flagMDStyle == "list":
+		columns = []string{"url", "title"}
+	}
+	if *flagColumns != "" {
+		columns, err = parseColumns(*flagColumns)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitCode(exitError))
+		}
+	}
+
+	if *flagWithDate && !slices.Contains(columns, "date") {
+		columns = append(slices.Clone(columns), "date")
+	}
+
+	if *flagDateFmt != "rfc3339" && *flagDateFmt != "relative" {
+		fmt.Fprintf(os.Stderr, "unknown date format %q (available: rfc3339, relative)\n", *flagDateFmt)
+		os.Exit(exitCode(exitError))
+	}
+	dateFormat = *flagDateFmt
+
+	opts := searchOptions{Sort: *flagSort, Reverse: *flagReverse, Limit: *flagLimit, FullText: *flagFTS, Exclude: excludedDomains}
+	if opts.Sort == "" {
+		opts.Sort = "date"
+		if interactive && !recent {
+			opts.Sort = "relevance"
+		}
+	}
+	// These formats only print the columns, the other fields are not read
+	switch format {
+	case "plain", "csv", "tsv", "table", "yaml":
+		opts.Columns = columns
+	}
+	if recent && opts.Limit == 0 {
+		opts.Limit = defaultRecentLimit
+	}
+	opts.Weights, err = parseRelevanceWeights(*flagWeights)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitCode(exitError))
+	}
+	opts.HalfLife, err = parseDuration(*flagHalfLife)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitCode(exitError))
+	}
+	if err := opts.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitCode(exitError))
+	}
+
+	color, err := useColor(*flagColor, interactive)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitCode(exitError))
 	}
 
-	query := os.Args[1]
-	if query == "" {
-		fmt.Fprintf(os.Stderr, "usage: ffs \"<query>\"\n")
-		os.Exit(1)
+	if *flagTimeout < 0 {
+		fmt.Fprintf(os.Stderr, "timeout must not be negative\n")
+		os.Exit(exitCode(exitError))
+	}
+
+	// Ctrl-C and --timeout stop taking the snapshot and the search, so what
+	// was written of the output and the snapshot is removed
+	ctx, stop := signalContext()
+	defer stop()
+	if *flagTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *flagTimeout)
+		defer cancel()
 	}
 
 	// Get the Firefox profile dir
 	profileDir, err := getFirefoxProfileDir()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to get Mozilla profile directory: %s\n", err)
-		os.Exit(1)
+		os.Exit(exitCode(exitError))
+	}
+
+	// A running daemon already has a snapshot and the index of full-text
+	// queries, the TUI preview and the promnesia visits still need one of
+	// their own. --pragma only applies to the connections of ffs.
+	var (
+		db     *sql.DB
+		search func(query string, opts searchOptions, fn func(*Result) error) error
+		count  func(query string) (int64, error)
+	)
+	var daemon *daemonClient
+	if !*flagNoDaemon && !bookmarks && len(readerPragmas) == 0 && format != "tui" && !(format == "format" && *flagFormat == "promnesia") {
+		daemon = dialDaemon(profileDir)
 	}
-	dbPath := profileDir + "/places.sqlite"
+	cleanup := func() {}
+	if daemon != nil {
+		search = func(query string, opts searchOptions, fn func(*Result) error) error {
+			return contextError(ctx, daemon.Search(ctx, query, opts, fn))
+		}
+		count = func(query string) (int64, error) {
+			n, err := daemon.Count(ctx, query, *flagFTS)
+			return n, contextError(ctx, err)
+		}
+	} else {
+		if format == "tui" || (format == "format" && *flagFormat == "promnesia") {
+			// The TUI stays open while Firefox writes, it searches a copy.
+			// Both look up the visits of every result by URL, which only
+			// the copy has an index for.
+			db, cleanup, err = openCachedPlaces(ctx, profileDir)
+		} else {
+			db, cleanup, err = openPlaces(ctx, profileDir)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", contextError(ctx, err))
+			os.Exit(exitCode(exitError))
+		}
 
-	// Copy the db to /tmp to avoid running into locks
-	if err := copyFile(dbPath, dbTmpPath); err != nil {
+		search = func(query string, opts searchOptions, fn func(*Result) error) error {
+			return contextError(ctx, searchHistory(ctx, db, query, opts, fn))
+		}
+		count = func(query string) (int64, error) {
+			n, err := countHistory(ctx, db, query)
+			return n, contextError(ctx, err)
+		}
+		if bookmarks {
+			search = func(query string, opts searchOptions, fn func(*Result) error) error {
+				return contextError(ctx, searchBookmarks(ctx, db, query, opts, fn))
+			}
+		}
+	}
+	defer cleanup()
+
+	if *flagFTS && daemon == nil {
+		index, closeIndex, err := openIndex(ctx, profileDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", contextError(ctx, err))
+			os.Exit(exitCode(exitError))
+		}
+		defer closeIndex()
+
+		search = func(query string, opts searchOptions, fn func(*Result) error) error {
+			return contextError(ctx, searchHistory(ctx, index, query, opts, fn))
+		}
+		count = func(query string) (int64, error) {
+			n, err := countFullText(ctx, index, query)
+			return n, contextError(ctx, err)
+		}
+	}
+
+	// Counting in SQL knows nothing of excluded domains or bookmarks, the
+	// results are counted
+	if len(excludedDomains) > 0 || bookmarks {
+		count = func(query string) (int64, error) {
+			var n int64
+			opts := searchOptions{Sort: "date", FullText: *flagFTS, Columns: []string{"url"}, Exclude: excludedDomains}
+			err := search(query, opts, func(*Result) error {
+				n++
+				return nil
+			})
+			return n, err
+		}
+	}
+
+	dst, err := openOutput(flagOutput)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
-		os.Exit(1)
+		os.Exit(exitCode(exitError))
 	}
-	defer os.Remove(dbTmpPath)
 
-	// Open the db
-	db, err := sql.Open("sqlite3", dbTmpPath)
+	sink, err := compressWriter(dst, *flagCompress)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to open database: %s\n", err)
-		os.Exit(1)
+		dst.Abort()
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitCode(exitError))
+	}
+
+	if format == "count" {
+		count, err := count(query)
+		if err != nil {
+			dst.Abort()
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitCode(exitError))
+		}
+
+		fmt.Fprintln(sink, count)
+		if err := sink.Close(); err != nil {
+			dst.Abort()
+			fmt.Fprintf(os.Stderr, "error writing results: %s\n", err)
+			os.Exit(exitCode(exitError))
+		}
+		if err := dst.Commit(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitCode(exitError))
+		}
+		if *flagSummary {
+			printSummary(count, 1, time.Since(start))
+		}
+		if count == 0 {
+			cleanup()
+			os.Exit(exitNoMatch)
+		}
+		return
+	}
+
+	if format == "interactive" || format == "tui" || format == "menu" {
+		var results []*Result
+		err := search(query, opts, func(r *Result) error {
+			results = append(results, r)
+			return nil
+		})
+		if err != nil {
+			dst.Abort()
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitCode(exitError))
+		}
+
+		var picked *Result
+		switch format {
+		case "tui":
+			picked, err = runTUI(db, results)
+		case "menu":
+			picked, err = pickWithMenu(*flagMenuCmd, results)
+		default:
+			picked, err = pickResult(results)
+		}
+		if err != nil {
+			dst.Abort()
+			if err == errPickCanceled {
+				os.Exit(exitNoMatch)
+			}
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitCode(exitError))
+		}
+
+		// The menu is a launcher, so its pick is always opened
+		open := *flagOpen || format == "menu"
+		if open || *flagCopy || *flagQR {
+			if err := actOnURLs([]string{picked.URL}, open); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(exitCode(exitError))
+			}
+			return
+		}
+
+		fmt.Fprintln(sink, picked.URL)
+		if err := sink.Close(); err != nil {
+			dst.Abort()
+			fmt.Fprintf(os.Stderr, "error writing results: %s\n", err)
+			os.Exit(exitCode(exitError))
+		}
+		if err := dst.Commit(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitCode(exitError))
+		}
+		return
 	}
-	defer db.Close()
 
-	// Prepare the query
-	pattern := convertToGlobPattern(query)
-	params := []interface{}{pattern, pattern, pattern}
+	if *flagOpen || *flagOpenAll || *flagCopy || *flagQR {
+		var urls []string
+		err := search(query, opts, func(r *Result) error {
+			urls = append(urls, r.URL)
+			if !*flagOpenAll {
+				return errStopSearch
+			}
+			return nil
+		})
+		if err != nil && err != errStopSearch {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitCode(exitError))
+		}
+		if len(urls) == 0 {
+			fmt.Fprintf(os.Stderr, "no results for %q\n", query)
+			os.Exit(exitNoMatch)
+		}
 
-	// Execute the query
-	rows, err := db.Query(histQuery, params...)
+		if err := actOnURLs(urls, *flagOpen || *flagOpenAll); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitCode(exitError))
+		}
+		return
+	}
+
+	if format == "rofi-resolve" {
+		var line string
+		if _, err := fmt.Fscanln(os.Stdin, &line); err != nil {
+			dst.Abort()
+			os.Exit(exitCode(exitError))
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(line))
+		if err != nil || n < 0 {
+			dst.Abort()
+			fmt.Fprintf(os.Stderr, "invalid row index %q\n", line)
+			os.Exit(exitCode(exitError))
+		}
+
+		// Same query, same order, so the nth result is the selected row
+		var picked *Result
+		i := 0
+		err = search(query, opts, func(r *Result) error {
+			if i == n {
+				picked = r
+				return errStopSearch
+			}
+			i++
+			return nil
+		})
+		if err != nil && err != errStopSearch {
+			dst.Abort()
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitCode(exitError))
+		}
+		if picked == nil {
+			dst.Abort()
+			fmt.Fprintf(os.Stderr, "no result at row %d\n", n)
+			os.Exit(exitCode(exitError))
+		}
+
+		fmt.Fprintln(sink, picked.URL)
+		if err := sink.Close(); err != nil {
+			dst.Abort()
+			fmt.Fprintf(os.Stderr, "error writing results: %s\n", err)
+			os.Exit(exitCode(exitError))
+		}
+		if err := dst.Commit(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitCode(exitError))
+		}
+		return
+	}
+
+	// Long output on a terminal goes through a pager
+	if interactive && !*flagNoPager && format != "export-sqlite" {
+		rows, _ := terminalSize(os.Stdout)
+		if *flagPager {
+			rows = 0
+		}
+		if rows > 0 || *flagPager {
+			sink = newPager(dst.File, rows)
+		}
+	}
+
+	var hl *highlighter
+	if color {
+		hl = newHighlighter(query)
+	}
+
+	var out resultWriter
+	switch format {
+	case "csv":
+		out = newCSVWriter(sink, columns, !*flagNoHeader)
+	case "tsv":
+		out = newTSVWriter(sink, columns, !*flagNoHeader)
+	case "markdown":
+		out = newMarkdownWriter(sink, *flagMDStyle == "table", columns)
+	case "html":
+		// Favicons are optional, the report works without them
+		icons, cleanup, err := openFaviconStore(profileDir)
+		if err == nil {
+			defer cleanup()
+		}
+		out = newHTMLWriter(sink, query, columns, icons)
+	case "yaml":
+		out = newYAMLWriter(sink, columns)
+	case "rss":
+		out = newRSSWriter(sink, query, projectURL)
+	case "format":
+		if *flagFormat == "promnesia" {
+			out = newPromnesiaWriter(sink, func(url string) ([]time.Time, error) {
+				return recentVisits(db, url, -1)
+			})
+			break
+		}
+
+		out, err = newTemplateWriter(sink, *flagFormat)
+		if err != nil {
+			dst.Abort()
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitCode(exitError))
+		}
+	case "table":
+		width := 0
+		if !*flagNoTrunc && interactive {
+			width = terminalWidth(os.Stdout)
+		}
+		out = newTableWriter(sink, columns, width, hl)
+	case "org":
+		out = newOrgWriter(sink)
+	case "rofi", "launcher":
+		// Icons are optional, rows work without them
+		var icons *faviconStore
+		iconsDir, err := faviconCacheDir()
+		if err == nil && (format == "launcher" || *flagRofiIcon) {
+			var cleanup func()
+			icons, cleanup, err = openFaviconStore(profileDir)
+			if err == nil {
+				defer cleanup()
+			}
+		}
+		if format == "launcher" {
+			out = newLauncherWriter(sink, icons, iconsDir)
+		} else {
+			out = newRofiWriter(sink, icons, iconsDir)
+		}
+	case "grep":
+		sep := ":"
+		if *flagNull {
+			sep = "\x00"
+		}
+		out = newGrepWriter(sink, filepath.Base(profileDir), sep, hl)
+	case "export-sqlite":
+		out, err = newSQLiteWriter(*flagExportDB, query)
+		if err != nil {
+			dst.Abort()
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitCode(exitError))
+		}
+	default:
+		if *flagGroupBy == "domain" {
+			out = newGroupWriter(sink, columns, hl)
+			break
+		}
+
+		terminator := "\n"
+		if flagPrint0 {
+			terminator = "\x00"
+		}
+		plain := newPlainWriter(sink, columns, terminator, hl)
+
+		// Numbered on a terminal, to be opened later with `ffs open <n>`
+		if interactive && !flagQuiet && !flagPrint0 {
+			plain.numbered = true
+			if err := saveLastQuery(lastQuery{Query: query, Options: opts, Bookmarks: bookmarks}); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+			}
+		}
+		out = plain
+	}
+
+	var matches int64
+	write := writeTo(out)
+	err = search(query, opts, func(r *Result) error {
+		matches++
+		return write(r)
+	})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "query failed: %v\n", err)
-		os.Exit(1)
+		dst.Abort()
+		if db, ok := out.(*sqliteWriter); ok {
+			db.Abort()
+		}
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitCode(exitError))
 	}
-	defer rows.Close()
 
-	// To track printed results
-	printedUrls := make(map[string]bool)
+	if err := out.Flush(); err != nil {
+		dst.Abort()
+		fmt.Fprintf(os.Stderr, "error writing results: %s\n", err)
+		os.Exit(exitCode(exitError))
+	}
 
-	for rows.Next() {
-		var url string
-		if err := rows.Scan(&url); err != nil {
-			fmt.Fprintf(os.Stderr, "error scanning row: %s\n", err)
-			continue
+	if err := sink.Close(); err != nil {
+		dst.Abort()
+		fmt.Fprintf(os.Stderr, "error writing results: %s\n", err)
+		os.Exit(exitCode(exitError))
+	}
+
+	if err := dst.Commit(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitCode(exitError))
+	}
+
+	if *flagSummary {
+		printSummary(matches, 1, time.Since(start))
+	}
+
+	if matches == 0 {
+		cleanup()
+		os.Exit(exitNoMatch)
+	}
+}
+
+// Returns why ctx ended if err is caused by that, the errors of the
+// queries only say they were interrupted
+func contextError(ctx context.Context, err error) error {
+	if err == nil || err == errStopSearch {
+		return err
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("timed out after %s", *flagTimeout)
+	case ctx.Err() != nil:
+		return fmt.Errorf("interrupted")
+	}
+
+	return err
+}
+
+// Opens urls in the browser if open is set, copies them to the clipboard
+// with --copy, one per line, and prints the first as QR code with --qr
+func actOnURLs(urls []string, open bool) error {
+	if open && *flagWindow {
+		if err := openWindow(*flagBrowser, urls); err != nil {
+			return err
+		}
+	} else if open {
+		browser := browserCommand(*flagBrowser)
+		for _, url := range urls {
+			if err := openURL(browser, url); err != nil {
+				return err
+			}
+		}
+	}
+
+	if *flagCopy {
+		if err := copyToClipboard(strings.Join(urls, "\n")); err != nil {
+			return err
 		}
+	}
+
+	if *flagQR {
+		color, _ := useColor(*flagColor, isTerminal(os.Stdout))
+		return printQRCode(os.Stdout, urls[0], color)
+	}
+
+	return nil
+}
+
+// Prints a summary of the search to stderr so piped output stays clean
+func printSummary(matches int64, profiles int, elapsed time.Duration) {
+	fmt.Fprintf(os.Stderr, "%d %s in %d %s (%s)\n",
+		matches, plural(matches, "match", "matches"),
+		profiles, plural(int64(profiles), "profile", "profiles"),
+		elapsed.Round(time.Millisecond))
+}
+
+// Returns singular if n is 1, plural otherwise
+func plural(n int64, singular, plural string) string {
+	if n == 1 {
+		return singular
+	}
+
+	return plural
+}
+
+// The flags selecting an output format of their own, which FFS_FORMAT can name
+var formatFlags = []string{"csv", "tsv", "markdown", "html", "yaml", "rss", "table", "org", "count", "interactive", "tui", "rofi", "menu", "grep-format"}
+
+// Returns the output format selected via flags, "plain" if none
+func outputFormat() (string, error) {
+	formats := []struct {
+		name string
+		set  bool
+	}{
+		{"csv", *flagCSV},
+		{"tsv", *flagTSV},
+		{"markdown", *flagMarkdown},
+		{"html", *flagHTML},
+		{"yaml", *flagYAML},
+		{"rss", *flagRSS},
+		{"format", *flagFormat != ""},
+		{"table", *flagTable},
+		{"org", *flagOrg},
+		{"export-sqlite", *flagExportDB != ""},
+		{"count", flagCount},
+		{"interactive", flagInteract},
+		{"tui", *flagTUI},
+		{"rofi", *flagRofi},
+		{"rofi-resolve", *flagResolve},
+		{"launcher", *flagLauncher != ""},
+		{"menu", *flagMenu},
+		{"grep", *flagGrep},
+	}
 
-		// Do not print if already printed
-		if _, ok := printedUrls[url]; ok {
+	// A format given on the command line replaces that of the environment,
+	// which replaces that of config.toml
+	source := func(name string) flagSource {
+		if name == "grep" {
+			name = "grep-format"
+		}
+		if s, ok := flagSources[name]; ok {
+			return s
+		}
+		return sourceArgs
+	}
+	var top flagSource
+	for _, f := range formats {
+		if f.set {
+			top = max(top, source(f.name))
+		}
+	}
+
+	format := "plain"
+	for _, f := range formats {
+		if !f.set || source(f.name) < top {
 			continue
 		}
+		if format != "plain" {
+			return "", fmt.Errorf("only one of --%s and --%s can be used", format, f.name)
+		}
+		format = f.name
+	}
+
+	if *flagMDStyle != "list" && *flagMDStyle != "table" {
+		return "", fmt.Errorf("unknown Markdown style %q (available: list, table)", *flagMDStyle)
+	}
+
+	if format == "launcher" && *flagLauncher != "json" {
+		return "", fmt.Errorf("unknown launcher format %q (available: json)", *flagLauncher)
+	}
 
-		printedUrls[url] = true
-		fmt.Println(url)
+	if !isStdout(flagOutput) && format == "export-sqlite" {
+		return "", fmt.Errorf("--output cannot be combined with --export-sqlite")
 	}
 
-	if err := rows.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "error iterating rows: %s\n", err)
-		os.Exit(1)
+	if *flagCompress != "" && format == "export-sqlite" {
+		return "", fmt.Errorf("--compress cannot be combined with --export-sqlite")
 	}
+
+	if *flagGroupBy != "" {
+		if *flagGroupBy != "domain" {
+			return "", fmt.Errorf("unknown grouping %q (available: domain)", *flagGroupBy)
+		}
+		if format != "plain" || flagPrint0 {
+			return "", fmt.Errorf("--group-by only works with the default output")
+		}
+	}
+
+	if *flagOpen && *flagOpenAll {
+		return "", fmt.Errorf("only one of --open and --open-all can be used")
+	}
+
+	if *flagOpen && format != "plain" && format != "interactive" && format != "tui" && format != "menu" {
+		return "", fmt.Errorf("--open cannot be combined with --%s", format)
+	}
+
+	if *flagOpenAll && format != "plain" {
+		return "", fmt.Errorf("--open-all cannot be combined with --%s", format)
+	}
+
+	if *flagWindow && !*flagOpenAll {
+		return "", fmt.Errorf("--window only works with --open-all")
+	}
+
+	if *flagCopy && format != "plain" && format != "interactive" && format != "tui" && format != "menu" {
+		return "", fmt.Errorf("--copy cannot be combined with --%s", format)
+	}
+
+	if *flagQR && format != "plain" && format != "interactive" && format != "tui" && format != "menu" {
+		return "", fmt.Errorf("--qr cannot be combined with --%s", format)
+	}
+
+	if *flagQR && *flagOpenAll {
+		return "", fmt.Errorf("--qr cannot be combined with --open-all")
+	}
+
+	if (*flagOpen || *flagOpenAll || *flagCopy || *flagQR) && (!isStdout(flagOutput) || *flagCompress != "") {
+		return "", fmt.Errorf("--output and --compress cannot be combined with --open, --open-all, --copy or --qr")
+	}
+
+	if *flagPager && *flagNoPager {
+		return "", fmt.Errorf("only one of --pager and --no-pager can be used")
+	}
+
+	if *flagNull && format != "grep" {
+		return "", fmt.Errorf("--null only works with --grep-format")
+	}
+
+	if flagPrint0 && format != "plain" {
+		return "", fmt.Errorf("--print0 cannot be combined with --%s", format)
+	}
+
+	return format, nil
 }
 
-// Returns the currently default Mozilla Firefox profile directory
-func getFirefoxProfileDir() (string, error) {
+// Parses flags from args and returns the positional arguments. Flags not
+// given are taken from config.toml. Unlike flag.Parse, flags are also
+// accepted after the query.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+
+		rest := fs.Args()
+		if len(rest) == 0 {
+			break
+		}
+
+		// Everything after "--" is positional
+		if i := len(args) - len(rest) - 1; i >= 0 && args[i] == "--" {
+			positional = append(positional, rest...)
+			break
+		}
+
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+
+	if err := applyConfig(fs); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return nil, err
+	}
+
+	return positional, nil
+}
+
+// Returns the directory of profiles.ini
+func firefoxDir() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", fmt.Errorf("could not get home directory: %s", err)
 	}
 
-	ffdir := homeDir + "/.mozilla/firefox"
-	profileDir, err := parseProfileIni(ffdir)
+	return homeDir + "/.mozilla/firefox", nil
+}
+
+// Returns the Mozilla Firefox profile directory selected with --profile, or
+// else the currently default one, with symlinks resolved
+func getFirefoxProfileDir() (string, error) {
+	ffdir, err := firefoxDir()
 	if err != nil {
 		return "", err
 	}
 
-	return ffdir + "/" + profileDir, nil
+	var profileDir string
+	if selectedProfile != "" {
+		profileDir, err = namedProfile(ffdir, selectedProfile)
+	} else {
+		profileDir, err = parseProfileIni(ffdir)
+	}
+	if err == errNoInstallProfile {
+		// Older and minimal profiles.ini have no [Install] section
+		profileDir, err = defaultProfile(ffdir)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Profiles with IsRelative=0 are referred to by their absolute path
+	if !filepath.IsAbs(profileDir) {
+		profileDir = ffdir + "/" + profileDir
+	}
+
+	return resolveProfileDir(profileDir)
 }
 
-// Parses the profiles.ini file to get the default Firefox profile
-func parseProfileIni(ffdir string) (string, error) {
-	iniPath := ffdir + "/profiles.ini"
-	iniFh, err := os.Open(iniPath)
+// Returns profileDir with symlinks resolved, as dotfile managers and synced
+// home directories often link profiles elsewhere. Snapshots and daemons of
+// a profile are then shared however it is linked to.
+func resolveProfileDir(profileDir string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(profileDir)
 	if err != nil {
-		return "", fmt.Errorf("could not open profiles.ini: %s", err)
+		return "", fmt.Errorf("could not resolve profile %s: %s", profileDir, err)
 	}
-	defer iniFh.Close()
 
-	// Read line by line until the regex matches
-	inDefaultProfile := false
-	scanner := bufio.NewScanner(iniFh)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if reDefaultProfile.MatchString(line) {
-			inDefaultProfile = true
+	if _, err := os.Stat(filepath.Join(resolved, "places.sqlite")); err != nil {
+		if resolved != profileDir {
+			return "", fmt.Errorf("profile %s resolves to %s, which has no places.sqlite", profileDir, resolved)
 		}
+		return "", fmt.Errorf("profile %s has no places.sqlite", profileDir)
+	}
 
-		// We are inside the correct block, get the value of Default
-		if inDefaultProfile && strings.HasPrefix(line, "Default=") {
-			profileName := strings.TrimPrefix(line, "Default=")
-			return profileName, nil
-		}
+	return resolved, nil
+}
 
-		if inDefaultProfile && line == "" {
-			break
+// Parses the profiles.ini file to get the default profile of the Firefox
+// installation selected with --install, or else of the firefox in $PATH.
+// If that has no [Install] section, the first one is used.
+func parseProfileIni(ffdir string) (string, error) {
+	installs, err := listInstalls(ffdir)
+	if err != nil {
+		return "", err
+	}
+	if len(installs) == 0 {
+		return "", errNoInstallProfile
+	}
+
+	if selectedInstall != "" {
+		var hashes []string
+		for _, in := range installs {
+			if strings.EqualFold(in.Hash, selectedInstall) {
+				return in.Default, nil
+			}
+			hashes = append(hashes, in.Hash)
 		}
+		return "", fmt.Errorf("unknown install %q (available: %s)", selectedInstall, strings.Join(hashes, ", "))
 	}
 
-	if err := scanner.Err(); err != nil {
-		return "", fmt.Errorf("error scanning profiles.ini: %s", err)
+	if hash, err := firefoxInstallHash(); err == nil {
+		for _, in := range installs {
+			if in.Hash == hash {
+				return in.Default, nil
+			}
+		}
 	}
 
-	return "", fmt.Errorf("could not find default-release profile")
+	return installs[0].Default, nil
 }
 
-// Copies src to dst
+// Returned by parseProfileIni if profiles.ini has no [Install] section
+// with a default profile
+var errNoInstallProfile = errors.New("could not find default-release profile")
+
+// Copies src to dst, which only the user may read
 func copyFile(src, dst string) error {
 	srcFh, err := os.Open(src)
 	if err != nil {
@@ -163,12 +1122,24 @@ func copyFile(src, dst string) error {
 	}
 	defer srcFh.Close()
 
-	dstFh, err := os.Create(dst)
+	dstFh, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return fmt.Errorf("could not create destination file: %s", err)
 	}
 	defer dstFh.Close()
 
+	// On copy-on-write filesystems like btrfs and XFS, the copy shares the
+	// blocks of src until either is written to. Elsewhere, it fails.
+	if reflink(dstFh, srcFh) {
+		return nil
+	}
+
+	// Rather than failing halfway through, e.g. on a small tmpfs
+	if err := checkSpace(dstFh, srcFh); err != nil {
+		os.Remove(dst)
+		return err
+	}
+
 	if _, err := io.Copy(dstFh, srcFh); err != nil {
 		return fmt.Errorf("could not copy file: %s", err)
 	}
@@ -176,6 +1147,20 @@ func copyFile(src, dst string) error {
 	return nil
 }
 
+// The FICLONE ioctl, from linux/fs.h
+const ficlone = 0x40049409
+
+// Makes dst a reflink of src, reports whether that worked
+func reflink(dst, src *os.File) bool {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), ficlone, src.Fd())
+	return errno == 0
+}
+
+// Converts a Firefox PRTime (microseconds since epoch) to a time.Time
+func prTimeToTime(prtime int64) time.Time {
+	return time.UnixMicro(prtime)
+}
+
 // Makes sure the query is a glob pattern
 func convertToGlobPattern(pattern string) string {
 	pattern = strings.TrimSpace(pattern)
@@ -187,3 +1172,40 @@ func convertToGlobPattern(pattern string) string {
 
 	return pattern
 }
+
+// Returns an error naming the position of a character class in pattern
+// that SQLite's GLOB would never match, instead of silently finding nothing
+func validateGlob(pattern string) error {
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '[' {
+			continue
+		}
+
+		// A ] right after [ or [^ is part of the class
+		start := i
+		j := i + 1
+		if j < len(runes) && runes[j] == '^' {
+			j++
+		}
+		first := j
+		if j < len(runes) && runes[j] == ']' {
+			j++
+		}
+		for j < len(runes) && runes[j] != ']' {
+			if j+2 < len(runes) && runes[j+1] == '-' && runes[j+2] != ']' && runes[j] > runes[j+2] {
+				return fmt.Errorf("invalid pattern %q: empty range %c-%c at position %d", pattern, runes[j], runes[j+2], j+1)
+			}
+			j++
+		}
+		if j == len(runes) {
+			if first < len(runes) && runes[first] == ']' {
+				return fmt.Errorf("invalid pattern %q: empty character class at position %d", pattern, start+1)
+			}
+			return fmt.Errorf("invalid pattern %q: [ at position %d is never closed by ]", pattern, start+1)
+		}
+		i = j
+	}
+
+	return nil
+}
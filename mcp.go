@@ -0,0 +1,305 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// The newest MCP revision implemented, older clients get their own
+	mcpProtocolVersion = "2025-06-18"
+	// How long a snapshot of the history is reused between tool calls
+	mcpSnapshotAge = 30 * time.Second
+	// How many results are returned unless the tool call asks for a limit
+	mcpDefaultLimit = 20
+	// How many recent visits get_page_metadata returns
+	mcpRecentVisits = 10
+)
+
+// The MCP revisions the server can speak
+var mcpProtocolVersions = []string{"2024-11-05", "2025-03-26", mcpProtocolVersion}
+
+// A tool as listed by tools/list
+type mcpTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+	Annotations map[string]any `json:"annotations,omitempty"`
+}
+
+// The tools offered to the assistant, all of them only read the history
+var mcpTools = []mcpTool{
+	{
+		Name:        "search_history",
+		Description: "Search the Firefox browsing history. The query matches URLs, titles and descriptions case-insensitively, * and ? are wildcards and words are matched in order.",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query": map[string]any{"type": "string", "description": `e.g. "github*rust" or "*" for everything`},
+				"sort":  map[string]any{"type": "string", "enum": slices.Sorted(maps.Keys(sortOrders)), "description": "order of the results, date (newest first) by default"},
+				"limit": map[string]any{"type": "integer", "minimum": 1, "description": fmt.Sprintf("maximum number of results, %d by default", mcpDefaultLimit)},
+			},
+			"required": []string{"query"},
+		},
+		Annotations: map[string]any{"readOnlyHint": true},
+	},
+	{
+		Name:        "search_bookmarks",
+		Description: "Search the Firefox bookmarks, oldest first. The query works like for search_history.",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query": map[string]any{"type": "string"},
+				"limit": map[string]any{"type": "integer", "minimum": 1, "description": fmt.Sprintf("maximum number of results, %d by default", mcpDefaultLimit)},
+			},
+			"required": []string{"query"},
+		},
+		Annotations: map[string]any{"readOnlyHint": true},
+	},
+	{
+		Name:        "get_page_metadata",
+		Description: "Get the title, description, visit count, frecency, recent visits and bookmark titles of a page in the history by its exact URL.",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"url": map[string]any{"type": "string"},
+			},
+			"required": []string{"url"},
+		},
+		Annotations: map[string]any{"readOnlyHint": true},
+	},
+}
+
+// The arguments of all tools, each uses a subset
+type mcpArgs struct {
+	Query string `json:"query"`
+	Sort  string `json:"sort"`
+	Limit int    `json:"limit"`
+	URL   string `json:"url"`
+}
+
+// What get_page_metadata returns
+type mcpPage struct {
+	jsonResult
+	RecentVisits []string `json:"recent_visits"`
+	Bookmarks    []string `json:"bookmarks"`
+}
+
+// Serves the history to an MCP client
+type mcpServer struct {
+	places *placesSnapshot
+}
+
+// Returns the flag set of the mcp subcommand
+func mcpFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("mcp", flag.ExitOnError)
+	profileFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: ffs mcp\n\n")
+		fmt.Fprintf(os.Stderr, "Serves search_history, search_bookmarks and get_page_metadata over MCP on stdio\n")
+	}
+	return fs
+}
+
+// Runs the mcp subcommand, a Model Context Protocol server on stdin/stdout
+// for local assistants, until the client closes stdin
+func runMCP(args []string) {
+	if _, err := parseArgs(mcpFlagSet(), args); err != nil {
+		os.Exit(exitError)
+	}
+
+	profileDir, err := getFirefoxProfileDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get Mozilla profile directory: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	s := &mcpServer{places: newPlacesSnapshot(profileDir, mcpSnapshotAge)}
+	defer s.places.Close()
+
+	if err := serveJSONRPC(os.Stdin, os.Stdout, s.handle); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		s.places.Close()
+		os.Exit(exitError)
+	}
+}
+
+// Answers an MCP request
+func (s *mcpServer) handle(method string, params json.RawMessage) (any, error) {
+	switch method {
+	case "initialize":
+		var p struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+
+		version := mcpProtocolVersion
+		if slices.Contains(mcpProtocolVersions, p.ProtocolVersion) {
+			version = p.ProtocolVersion
+		}
+
+		return map[string]any{
+			"protocolVersion": version,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]any{"name": "ffs", "version": "1.0.0"},
+			"instructions":    "Read-only access to the user's Firefox history and bookmarks.",
+		}, nil
+	case "ping":
+		return nil, nil
+	case "tools/list":
+		return map[string]any{"tools": mcpTools}, nil
+	case "tools/call":
+		var p struct {
+			Name      string  `json:"name"`
+			Arguments mcpArgs `json:"arguments"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+
+		return s.callTool(p.Name, p.Arguments)
+	}
+
+	if strings.HasPrefix(method, "notifications/") {
+		return nil, nil
+	}
+
+	return nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("unknown method %q", method)}
+}
+
+// Runs a tool, failures of the tool itself are reported to the assistant
+// as an error result instead of a protocol error
+func (s *mcpServer) callTool(name string, args mcpArgs) (any, error) {
+	var (
+		out any
+		err error
+	)
+	switch name {
+	case "search_history":
+		out, err = s.searchHistory(args)
+	case "search_bookmarks":
+		out, err = s.searchBookmarks(args)
+	case "get_page_metadata":
+		out, err = s.pageMetadata(args)
+	default:
+		return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("unknown tool %q", name)}
+	}
+
+	if err != nil {
+		return mcpToolResult(err.Error(), true), nil
+	}
+
+	text, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+
+	return mcpToolResult(string(text), false), nil
+}
+
+// Returns a tools/call result with a single text content
+func mcpToolResult(text string, isError bool) map[string]any {
+	return map[string]any{
+		"content": []map[string]any{{"type": "text", "text": text}},
+		"isError": isError,
+	}
+}
+
+func (s *mcpServer) searchHistory(args mcpArgs) ([]jsonResult, error) {
+	if args.Query == "" {
+		return nil, fmt.Errorf("query must not be empty")
+	}
+
+	opts := defaultSearchOptions
+	if args.Sort != "" {
+		opts.Sort = args.Sort
+	}
+	opts.Limit = mcpLimit(args.Limit)
+
+	results := []jsonResult{}
+	err := s.places.With(func(db *sql.DB) error {
+		return searchHistory(context.Background(), db, args.Query, opts, func(res *Result) error {
+			results = append(results, newJSONResult(res))
+			return nil
+		})
+	})
+
+	return results, err
+}
+
+func (s *mcpServer) searchBookmarks(args mcpArgs) ([]jsonResult, error) {
+	if args.Query == "" {
+		return nil, fmt.Errorf("query must not be empty")
+	}
+
+	limit := mcpLimit(args.Limit)
+	results := []jsonResult{}
+	err := s.places.With(func(db *sql.DB) error {
+		return searchBookmarks(context.Background(), db, args.Query, searchOptions{Limit: limit}, func(res *Result) error {
+			results = append(results, newJSONResult(res))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (s *mcpServer) pageMetadata(args mcpArgs) (*mcpPage, error) {
+	if args.URL == "" {
+		return nil, fmt.Errorf("url must not be empty")
+	}
+
+	var page *mcpPage
+	err := s.places.With(func(db *sql.DB) error {
+		res, err := lookupPage(db, args.URL)
+		if err != nil {
+			return err
+		}
+		if res == nil {
+			return fmt.Errorf("%s is not in the history", args.URL)
+		}
+
+		visits, err := recentVisits(db, args.URL, mcpRecentVisits)
+		if err != nil {
+			return err
+		}
+		bookmarks, err := bookmarkTitles(db, args.URL)
+		if err != nil {
+			return err
+		}
+
+		page = &mcpPage{jsonResult: newJSONResult(res), RecentVisits: []string{}, Bookmarks: []string{}}
+		for _, v := range visits {
+			page.RecentVisits = append(page.RecentVisits, v.UTC().Format(time.RFC3339))
+		}
+		page.Bookmarks = append(page.Bookmarks, bookmarks...)
+
+		return nil
+	})
+
+	return page, err
+}
+
+// Returns the limit asked for, or the default
+func mcpLimit(limit int) int {
+	if limit <= 0 {
+		return mcpDefaultLimit
+	}
+
+	return limit
+}
@@ -0,0 +1,135 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Upper bounds of the query latency histogram buckets, in seconds
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// A Prometheus histogram of one label value
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, le := range latencyBuckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// The metrics of the long running modes, exposed at /metrics
+type metricsRegistry struct {
+	mu            sync.Mutex
+	queries       map[string]uint64
+	queryErrors   map[string]uint64
+	results       map[string]uint64
+	latency       map[string]*histogram
+	refreshes     uint64
+	refreshErrors uint64
+}
+
+// The metrics of this process
+var metrics = &metricsRegistry{
+	queries:     map[string]uint64{},
+	queryErrors: map[string]uint64{},
+	results:     map[string]uint64{},
+	latency:     map[string]*histogram{},
+}
+
+// Records a query answered by endpoint, which took since start and
+// returned results results
+func (m *metricsRegistry) observeQuery(endpoint string, start time.Time, results int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.queries[endpoint]++
+	if err != nil {
+		m.queryErrors[endpoint]++
+	}
+	m.results[endpoint] += uint64(results)
+
+	h, ok := m.latency[endpoint]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latency[endpoint] = h
+	}
+	h.observe(time.Since(start).Seconds())
+}
+
+// Records that a new snapshot of the history was taken
+func (m *metricsRegistry) observeRefresh(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.refreshes++
+	if err != nil {
+		m.refreshErrors++
+	}
+}
+
+// Writes the metrics in the Prometheus text format
+func (m *metricsRegistry) write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	endpoints := slices.Sorted(maps.Keys(m.queries))
+
+	var err error
+	printf := func(format string, args ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+
+	counters := []struct {
+		name, help string
+		values     map[string]uint64
+	}{
+		{"ffs_queries_total", "Searches answered, by endpoint.", m.queries},
+		{"ffs_query_errors_total", "Searches that failed, by endpoint.", m.queryErrors},
+		{"ffs_results_total", "Results returned, by endpoint.", m.results},
+	}
+	for _, c := range counters {
+		printf("# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		for _, endpoint := range endpoints {
+			printf("%s{endpoint=%q} %d\n", c.name, endpoint, c.values[endpoint])
+		}
+	}
+
+	printf("# HELP ffs_query_duration_seconds Time taken to answer a search, by endpoint.\n# TYPE ffs_query_duration_seconds histogram\n")
+	for _, endpoint := range endpoints {
+		h := m.latency[endpoint]
+		for i, le := range latencyBuckets {
+			printf("ffs_query_duration_seconds_bucket{endpoint=%q,le=\"%g\"} %d\n", endpoint, le, h.counts[i])
+		}
+		printf("ffs_query_duration_seconds_bucket{endpoint=%q,le=\"+Inf\"} %d\n", endpoint, h.count)
+		printf("ffs_query_duration_seconds_sum{endpoint=%q} %g\n", endpoint, h.sum)
+		printf("ffs_query_duration_seconds_count{endpoint=%q} %d\n", endpoint, h.count)
+	}
+
+	printf("# HELP ffs_snapshot_refreshes_total Snapshots of the history taken.\n# TYPE ffs_snapshot_refreshes_total counter\nffs_snapshot_refreshes_total %d\n", m.refreshes)
+	printf("# HELP ffs_snapshot_refresh_errors_total Snapshots of the history that failed.\n# TYPE ffs_snapshot_refresh_errors_total counter\nffs_snapshot_refresh_errors_total %d\n", m.refreshErrors)
+
+	return err
+}
+
+// Serves the metrics to Prometheus
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.write(w)
+}
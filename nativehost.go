@@ -0,0 +1,247 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// The name of the native messaging host, as used by the extension
+	nativeHostName = "ffs"
+	// The ID of the companion extension allowed to talk to the host
+	nativeHostExtensionID = "ffs@rtfmkiesel.github.io"
+	// Firefox rejects messages from a host larger than 1 MB
+	nativeMaxMessage = 1 << 20
+	// How many results are returned unless the request asks for a limit
+	nativeDefaultLimit = 100
+)
+
+// A search request sent by the extension
+type nativeRequest struct {
+	Query string `json:"query"`
+	Sort  string `json:"sort,omitempty"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+// The answer to a nativeRequest
+type nativeResponse struct {
+	Results   []jsonResult `json:"results"`
+	Truncated bool         `json:"truncated,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// Runs the native-host subcommand, answering search requests of the
+// companion extension on stdin/stdout until Firefox closes stdin. Firefox
+// passes the manifest path and the extension ID as args, neither is needed.
+func runNativeHost(args []string) {
+	for {
+		var req nativeRequest
+		err := readNativeMessage(os.Stdin, &req)
+		if errors.Is(err, io.EOF) {
+			return
+		}
+
+		var resp nativeResponse
+		if err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp = nativeSearch(req)
+		}
+
+		if err := writeNativeMessage(os.Stdout, resp); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitError)
+		}
+	}
+}
+
+// Searches the history of the default profile for req
+func nativeSearch(req nativeRequest) nativeResponse {
+	resp := nativeResponse{Results: []jsonResult{}}
+	if req.Query == "" {
+		resp.Error = "empty query"
+		return resp
+	}
+
+	opts := defaultSearchOptions
+	if req.Sort != "" {
+		opts.Sort = req.Sort
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = nativeDefaultLimit
+	}
+
+	profileDir, err := getFirefoxProfileDir()
+	if err != nil {
+		resp.Error = fmt.Sprintf("failed to get Mozilla profile directory: %s", err)
+		return resp
+	}
+
+	// A fresh snapshot per request, the history changes while the browser runs
+	db, cleanup, err := openPlaces(context.Background(), profileDir)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	defer cleanup()
+
+	// Leave room for the rest of the response
+	size := 64
+	err = searchHistory(context.Background(), db, req.Query, opts, func(r *Result) error {
+		res := newJSONResult(r)
+		data, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		if len(resp.Results) == limit || size+len(data)+1 > nativeMaxMessage {
+			resp.Truncated = true
+			return errStopSearch
+		}
+
+		size += len(data) + 1
+		resp.Results = append(resp.Results, res)
+		return nil
+	})
+	if err != nil && err != errStopSearch {
+		resp.Error = err.Error()
+	}
+
+	return resp
+}
+
+// Reads a message of the native messaging protocol into v: a 32-bit
+// length in native byte order followed by that much JSON
+func readNativeMessage(r io.Reader, v any) error {
+	var size uint32
+	if err := binary.Read(r, binary.NativeEndian, &size); err != nil {
+		return err
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return fmt.Errorf("could not read message: %s", err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid message: %s", err)
+	}
+
+	return nil
+}
+
+// Writes v as a message of the native messaging protocol
+func writeNativeMessage(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("could not encode message: %s", err)
+	}
+
+	if err := binary.Write(w, binary.NativeEndian, uint32(len(data))); err != nil {
+		return fmt.Errorf("could not write message: %s", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("could not write message: %s", err)
+	}
+
+	return nil
+}
+
+// The manifest telling Firefox how to start the native messaging host
+type nativeManifest struct {
+	Name              string   `json:"name"`
+	Description       string   `json:"description"`
+	Path              string   `json:"path"`
+	Type              string   `json:"type"`
+	AllowedExtensions []string `json:"allowed_extensions"`
+}
+
+// The flags of the install-native-host subcommand
+var installNativeHostFlags struct {
+	extensionID string
+}
+
+// Returns the flag set of the install-native-host subcommand
+func installNativeHostFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("install-native-host", flag.ExitOnError)
+	fs.StringVar(&installNativeHostFlags.extensionID, "extension-id", nativeHostExtensionID, "`ID` of the extension allowed to use the host")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: ffs install-native-host [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Runs the install-native-host subcommand, registering the native messaging
+// host for the current user
+func runInstallNativeHost(args []string) {
+	if _, err := parseArgs(installNativeHostFlagSet(), args); err != nil {
+		os.Exit(exitError)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not find the ffs binary: %s\n", err)
+		os.Exit(exitError)
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not find the ffs binary: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not get home directory: %s\n", err)
+		os.Exit(exitError)
+	}
+	hostsDir := filepath.Join(homeDir, ".mozilla", "native-messaging-hosts")
+	if err := os.MkdirAll(hostsDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "could not create %s: %s\n", hostsDir, err)
+		os.Exit(exitError)
+	}
+
+	// Firefox starts the host without arguments of our own, so the
+	// manifest points to a script adding the subcommand
+	script := filepath.Join(hostsDir, nativeHostName+"-host.sh")
+	content := fmt.Sprintf("#!/bin/sh\nexec %s native-host \"$@\"\n", shellQuote(exe))
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "could not write %s: %s\n", script, err)
+		os.Exit(exitError)
+	}
+
+	manifest, err := json.MarshalIndent(nativeManifest{
+		Name:              nativeHostName,
+		Description:       "Search the Firefox history with ffs",
+		Path:              script,
+		Type:              "stdio",
+		AllowedExtensions: []string{installNativeHostFlags.extensionID},
+	}, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not encode manifest: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	manifestPath := filepath.Join(hostsDir, nativeHostName+".json")
+	if err := os.WriteFile(manifestPath, append(manifest, '\n'), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "could not write %s: %s\n", manifestPath, err)
+		os.Exit(exitError)
+	}
+
+	fmt.Println(manifestPath)
+}
+
+// Quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
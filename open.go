@@ -0,0 +1,173 @@
+//go:build linux
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Returns the command used to open URLs: --browser, the first entry of
+// $BROWSER or xdg-open
+func browserCommand(flagBrowser string) string {
+	if flagBrowser != "" {
+		return flagBrowser
+	}
+
+	// $BROWSER may list several browsers separated by colons
+	if env, _, _ := strings.Cut(os.Getenv("BROWSER"), ":"); strings.TrimSpace(env) != "" {
+		return env
+	}
+
+	return "xdg-open"
+}
+
+// Opens url with the browser command without waiting for it. A %s in the
+// command is replaced by the URL, otherwise it is appended.
+func openURL(browser, url string) error {
+	args := strings.Fields(browser)
+	if len(args) == 0 {
+		return fmt.Errorf("no browser command")
+	}
+
+	replaced := false
+	for i, arg := range args {
+		if strings.Contains(arg, "%s") {
+			args[i] = strings.ReplaceAll(arg, "%s", url)
+			replaced = true
+		}
+	}
+	if !replaced {
+		args = append(args, url)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not open %s: %s", url, err)
+	}
+
+	// Do not leave a zombie behind while ffs is still running
+	go cmd.Wait()
+	return nil
+}
+
+// Opens urls as tabs of a new window of Firefox, firefox is the command
+// starting it, "firefox" if empty
+func openWindow(firefox string, urls []string) error {
+	args := strings.Fields(firefox)
+	if len(args) == 0 {
+		args = []string{"firefox"}
+	}
+
+	// Firefox opens several URLs given at once in a new window, a single one
+	// would end up in the current window without --new-window
+	if len(urls) == 1 {
+		args = append(args, "--new-window")
+	}
+	args = append(args, urls...)
+
+	cmd := exec.Command(args[0], args[1:]...)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not start %s: %s", args[0], err)
+	}
+
+	go cmd.Wait()
+	return nil
+}
+
+// The flags of the open subcommand
+var openFlags struct {
+	browser string
+}
+
+// Returns the flag set of the open subcommand
+func openFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("open", flag.ExitOnError)
+	profileFlag(fs)
+	fs.StringVar(&openFlags.browser, "browser", "", "`command` to open URLs with, %s is replaced by the URL (default $BROWSER or xdg-open)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: ffs open [flags] <n>\n\n")
+		fmt.Fprintf(os.Stderr, "Opens result n of the last search printed to a terminal.\n\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Runs the open subcommand, opening the nth result of the last search
+func runOpen(args []string) {
+	fs := openFlagSet()
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		os.Exit(exitError)
+	}
+
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(exitError)
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		fmt.Fprintf(os.Stderr, "invalid result number %q\n", args[0])
+		os.Exit(exitError)
+	}
+
+	last, err := loadLastQuery()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitError)
+	}
+
+	profileDir, err := getFirefoxProfileDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get Mozilla profile directory: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	open := openPlaces
+	if last.Options.FullText {
+		open = openIndex
+	}
+	ctx, stop := signalContext()
+	defer stop()
+
+	db, cleanup, err := open(ctx, profileDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", contextError(ctx, err))
+		os.Exit(exitCode(exitError))
+	}
+	defer cleanup()
+
+	// Same query, same options, so the nth result is the one printed as n
+	var picked *Result
+	i := 0
+	pick := func(r *Result) error {
+		i++
+		if i == n {
+			picked = r
+			return errStopSearch
+		}
+		return nil
+	}
+	if last.Bookmarks {
+		err = searchBookmarks(ctx, db, last.Query, last.Options, pick)
+	} else {
+		err = searchHistory(ctx, db, last.Query, last.Options, pick)
+	}
+	if err != nil && err != errStopSearch {
+		fmt.Fprintf(os.Stderr, "%s\n", contextError(ctx, err))
+		os.Exit(exitCode(exitError))
+	}
+	if picked == nil {
+		fmt.Fprintf(os.Stderr, "no result %d for %q\n", n, last.Query)
+		os.Exit(exitNoMatch)
+	}
+
+	if err := openURL(browserCommand(openFlags.browser), picked.URL); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitError)
+	}
+}
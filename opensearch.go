@@ -0,0 +1,86 @@
+//go:build linux
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// How many suggestions are returned to the browser
+const suggestLimit = 10
+
+// The OpenSearch description of ffs serve, to add it as a search engine
+const openSearchDescription = `<?xml version="1.0" encoding="UTF-8"?>
+<OpenSearchDescription xmlns="http://a9.com/-/spec/opensearch/1.1/" xmlns:moz="http://www.mozilla.org/2006/browser/search/">
+  <ShortName>ffs</ShortName>
+  <Description>Search your Firefox history</Description>
+  <InputEncoding>UTF-8</InputEncoding>
+  <Url type="text/html" method="get" template="%[1]s?q={searchTerms}%[2]s"/>
+  <Url type="application/x-suggestions+json" method="get" template="%[1]ssuggest?q={searchTerms}%[2]s"/>
+  <moz:SearchForm>%[1]s</moz:SearchForm>
+</OpenSearchDescription>
+`
+
+// Serves the OpenSearch description, with the URLs of the host and path
+// prefix it was requested from. A token it was requested with is kept.
+func (s *server) handleOpenSearch(w http.ResponseWriter, r *http.Request) {
+	// The path before any prefix was stripped
+	path, _, _ := strings.Cut(r.RequestURI, "?")
+	path = strings.TrimSuffix(path, "opensearch.xml")
+
+	var token string
+	if t := r.URL.Query().Get("access_token"); t != "" {
+		token = "&access_token=" + url.QueryEscape(t)
+	}
+
+	var base, params strings.Builder
+	xml.EscapeText(&base, []byte("http://"+r.Host+path))
+	xml.EscapeText(&params, []byte(token))
+
+	w.Header().Set("Content-Type", "application/opensearchdescription+xml")
+	fmt.Fprintf(w, openSearchDescription, base.String(), params.String())
+}
+
+// Answers suggestion requests of the browser as the user types, following
+// the OpenSearch suggestions extension:
+//
+//	["query", ["title", ...], ["description", ...], ["url", ...]]
+func (s *server) handleSuggest(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+
+	titles, descriptions, urls := []string{}, []string{}, []string{}
+	if query != "" {
+		start := time.Now()
+		opts := defaultSearchOptions
+		opts.Sort = "relevance"
+		opts.Limit = suggestLimit
+
+		err := s.places.With(func(db *sql.DB) error {
+			return searchHistory(r.Context(), db, query, opts, func(res *Result) error {
+				title := res.Title
+				if title == "" {
+					title = res.URL
+				}
+				titles = append(titles, title)
+				descriptions = append(descriptions, res.Description)
+				urls = append(urls, res.URL)
+				return nil
+			})
+		})
+		metrics.observeQuery("suggest", start, len(urls), err)
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-suggestions+json")
+	json.NewEncoder(w).Encode([]any{query, titles, descriptions, urls})
+}
@@ -0,0 +1,154 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// How long results may wait in the output buffer, so the results of a slow
+// search still show up while it runs
+const outputFlushInterval = 100 * time.Millisecond
+
+// Where results are written to. Writes are buffered until Commit. Files are
+// written atomically: results go to a temporary file next to the target
+// which replaces it on Commit.
+type outputFile struct {
+	*os.File
+	path string
+	buf  *timedBuffer
+}
+
+// Opens the output for path, "" and "-" mean stdout
+func openOutput(path string) (*outputFile, error) {
+	if isStdout(path) {
+		return &outputFile{File: os.Stdout, buf: newTimedBuffer(os.Stdout)}, nil
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("could not create output file: %s", err)
+	}
+
+	return &outputFile{File: f, path: path, buf: newTimedBuffer(f)}, nil
+}
+
+func (o *outputFile) Write(p []byte) (int, error) {
+	return o.buf.Write(p)
+}
+
+func (o *outputFile) WriteString(s string) (int, error) {
+	return io.WriteString(o.buf, s)
+}
+
+// Moves the written file into place, keeping the mode of an existing file
+func (o *outputFile) Commit() error {
+	if err := o.buf.Flush(); err != nil {
+		o.Abort()
+		return fmt.Errorf("could not write output: %s", err)
+	}
+
+	if o.path == "" {
+		return nil
+	}
+
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(o.path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	if err := o.Chmod(mode); err != nil {
+		o.Abort()
+		return fmt.Errorf("could not write output file: %s", err)
+	}
+
+	if err := o.Close(); err != nil {
+		os.Remove(o.Name())
+		return fmt.Errorf("could not write output file: %s", err)
+	}
+
+	if err := os.Rename(o.Name(), o.path); err != nil {
+		os.Remove(o.Name())
+		return fmt.Errorf("could not write output file: %s", err)
+	}
+
+	return nil
+}
+
+// Discards the written file, leaving an existing target untouched
+func (o *outputFile) Abort() {
+	o.buf.Stop()
+	if o.path == "" {
+		return
+	}
+
+	o.Close()
+	os.Remove(o.Name())
+}
+
+// Reports whether the --output path means stdout
+func isStdout(path string) bool {
+	return path == "" || path == "-"
+}
+
+// Buffers writes to w, flushing once the buffer is full or
+// outputFlushInterval after the first write that is still buffered
+type timedBuffer struct {
+	mu    sync.Mutex
+	w     *bufio.Writer
+	timer *time.Timer
+}
+
+func newTimedBuffer(w io.Writer) *timedBuffer {
+	return &timedBuffer{w: bufio.NewWriterSize(w, 64*1024)}
+}
+
+func (b *timedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n, err := b.w.Write(p)
+	if b.timer == nil && b.w.Buffered() > 0 {
+		b.timer = time.AfterFunc(outputFlushInterval, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			b.timer = nil
+			// A failed write is returned by the next Write or Flush
+			b.w.Flush()
+		})
+	}
+
+	return n, err
+}
+
+// Writes everything buffered to w
+func (b *timedBuffer) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.stop()
+	return b.w.Flush()
+}
+
+// Stops flushing, discarding what is buffered
+func (b *timedBuffer) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.stop()
+	b.w.Reset(io.Discard)
+}
+
+func (b *timedBuffer) stop() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+}
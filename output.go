@@ -0,0 +1,559 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+var (
+	// Columns that can be selected with --columns
+	allColumns = []string{"url", "title", "date", "visits", "frecency"}
+	// Columns printed by the tabular formats unless --columns is given
+	defaultColumns = []string{"url", "title", "date"}
+	// Columns that hold integers
+	numericColumns = map[string]bool{"visits": true, "frecency": true}
+)
+
+// A single history entry returned by a search
+type Result struct {
+	URL         string
+	Title       string
+	Description string
+	VisitCount  int64
+	Frecency    int64
+	LastVisit   time.Time
+	// Only set for bookmarks
+	Added time.Time
+}
+
+// Writes results in a specific output format
+type resultWriter interface {
+	WriteResult(r *Result) error
+	Flush() error
+}
+
+// Returns a search callback writing every result to w
+func writeTo(w resultWriter) func(*Result) error {
+	return func(r *Result) error {
+		if err := w.WriteResult(r); err != nil {
+			return fmt.Errorf("error writing result: %s", err)
+		}
+		return nil
+	}
+}
+
+// Writes the selected columns separated by tabs (by default just the URL),
+// each result followed by a terminator (usually a newline)
+type plainWriter struct {
+	w          io.Writer
+	columns    []string
+	terminator string
+	hl         *highlighter
+	// Prefixes each result with its 1-based index
+	numbered bool
+	index    int
+}
+
+// Creates a new plain writer, hl may be nil to disable highlighting
+func newPlainWriter(w io.Writer, columns []string, terminator string, hl *highlighter) *plainWriter {
+	return &plainWriter{w: w, columns: columns, terminator: terminator, hl: hl}
+}
+
+func (p *plainWriter) WriteResult(r *Result) error {
+	values := columnValues(r, p.columns)
+	for i, col := range p.columns {
+		values[i] = sanitizeText(values[i])
+		if col == "url" || col == "title" {
+			values[i] = p.hl.Highlight(values[i])
+		}
+	}
+
+	if p.numbered {
+		p.index++
+		values = append([]string{strconv.Itoa(p.index)}, values...)
+	}
+
+	_, err := io.WriteString(p.w, strings.Join(values, "\t")+p.terminator)
+	return err
+}
+
+func (p *plainWriter) Flush() error {
+	return nil
+}
+
+// Writes results as CSV with an optional header row
+type csvWriter struct {
+	w          *csv.Writer
+	columns    []string
+	header     bool
+	headerDone bool
+}
+
+func newCSVWriter(w io.Writer, columns []string, header bool) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w), columns: columns, header: header}
+}
+
+func (c *csvWriter) WriteResult(r *Result) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+
+	values := columnValues(r, c.columns)
+	for i := range values {
+		values[i] = sanitizeField(values[i])
+	}
+
+	return c.w.Write(values)
+}
+
+func (c *csvWriter) Flush() error {
+	// Still emit the header if there were no results
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// Writes the header row once, if enabled
+func (c *csvWriter) writeHeader() error {
+	if !c.header || c.headerDone {
+		return nil
+	}
+
+	c.headerDone = true
+	return c.w.Write(c.columns)
+}
+
+// Writes results as tab-separated values with an optional header row.
+// Tabs, newlines and backslashes inside fields are escaped so every
+// result stays on a single line.
+type tsvWriter struct {
+	w          io.Writer
+	columns    []string
+	header     bool
+	headerDone bool
+}
+
+func newTSVWriter(w io.Writer, columns []string, header bool) *tsvWriter {
+	return &tsvWriter{w: w, columns: columns, header: header}
+}
+
+func (t *tsvWriter) WriteResult(r *Result) error {
+	if err := t.writeHeader(); err != nil {
+		return err
+	}
+
+	return t.writeRow(columnValues(r, t.columns))
+}
+
+func (t *tsvWriter) Flush() error {
+	return t.writeHeader()
+}
+
+// Writes the header row once, if enabled
+func (t *tsvWriter) writeHeader() error {
+	if !t.header || t.headerDone {
+		return nil
+	}
+
+	t.headerDone = true
+	return t.writeRow(t.columns)
+}
+
+func (t *tsvWriter) writeRow(fields []string) error {
+	escaped := make([]string, len(fields))
+	for i, field := range fields {
+		// Tabs and line breaks are escaped first, sanitizeText only has the
+		// other control characters left
+		escaped[i] = sanitizeText(tsvEscaper.Replace(field))
+	}
+
+	_, err := fmt.Fprintln(t.w, strings.Join(escaped, "\t"))
+	return err
+}
+
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// Writes results as a Markdown bullet list of links or as a Markdown table
+type markdownWriter struct {
+	w          io.Writer
+	table      bool
+	columns    []string
+	headerDone bool
+}
+
+func newMarkdownWriter(w io.Writer, table bool, columns []string) *markdownWriter {
+	return &markdownWriter{w: w, table: table, columns: columns}
+}
+
+func (m *markdownWriter) WriteResult(r *Result) error {
+	if !m.table {
+		// The link covers url and title, other columns are appended
+		var extra []string
+		for i, value := range columnValues(r, m.columns) {
+			if col := m.columns[i]; col != "url" && col != "title" && value != "" {
+				extra = append(extra, markdownTextEscaper.Replace(sanitizeText(value)))
+			}
+		}
+
+		line := "- " + markdownLink(r)
+		if len(extra) > 0 {
+			line += " (" + strings.Join(extra, ", ") + ")"
+		}
+
+		_, err := io.WriteString(m.w, line+"\n")
+		return err
+	}
+
+	if err := m.writeHeader(); err != nil {
+		return err
+	}
+
+	cells := columnValues(r, m.columns)
+	for i, cell := range cells {
+		cells[i] = markdownCellEscaper.Replace(sanitizeText(cell))
+	}
+
+	_, err := fmt.Fprintf(m.w, "| %s |\n", strings.Join(cells, " | "))
+	return err
+}
+
+func (m *markdownWriter) Flush() error {
+	if !m.table {
+		return nil
+	}
+
+	return m.writeHeader()
+}
+
+// Writes the table header and delimiter rows once
+func (m *markdownWriter) writeHeader() error {
+	if m.headerDone {
+		return nil
+	}
+	m.headerDone = true
+
+	delims := make([]string, len(m.columns))
+	for i := range delims {
+		delims[i] = "---"
+	}
+
+	_, err := fmt.Fprintf(m.w, "| %s |\n| %s |\n", strings.Join(m.columns, " | "), strings.Join(delims, " | "))
+	return err
+}
+
+// Returns r as a Markdown link, falling back to the URL as link text
+func markdownLink(r *Result) string {
+	text := r.Title
+	if text == "" {
+		text = r.URL
+	}
+
+	return fmt.Sprintf("[%s](%s)", markdownTextEscaper.Replace(sanitizeText(text)), markdownURLEscaper.Replace(sanitizeText(r.URL)))
+}
+
+var (
+	markdownTextEscaper = strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, "\n", " ", "\r", " ")
+	markdownURLEscaper  = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29")
+	markdownCellEscaper = strings.NewReplacer("|", `\|`, "\n", " ", "\r", " ")
+)
+
+// Writes results as a YAML sequence of mappings
+type yamlWriter struct {
+	w       io.Writer
+	columns []string
+	written bool
+}
+
+func newYAMLWriter(w io.Writer, columns []string) *yamlWriter {
+	return &yamlWriter{w: w, columns: columns}
+}
+
+func (y *yamlWriter) WriteResult(r *Result) error {
+	y.written = true
+
+	var sb strings.Builder
+	for i, value := range columnValues(r, y.columns) {
+		prefix := "  "
+		if i == 0 {
+			prefix = "- "
+		}
+		if !numericColumns[y.columns[i]] {
+			value = yamlQuote(value)
+		}
+		sb.WriteString(prefix + y.columns[i] + ": " + value + "\n")
+	}
+
+	_, err := io.WriteString(y.w, sb.String())
+	return err
+}
+
+func (y *yamlWriter) Flush() error {
+	if y.written {
+		return nil
+	}
+
+	// An empty sequence instead of an empty document
+	_, err := io.WriteString(y.w, "[]\n")
+	return err
+}
+
+// Quotes s as a YAML double-quoted scalar. JSON string escapes are a
+// subset of the YAML ones, so encoding/json does the heavy lifting.
+func yamlQuote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// Writes results as Org mode headings with the visit details as properties
+type orgWriter struct {
+	w io.Writer
+}
+
+func newOrgWriter(w io.Writer) *orgWriter {
+	return &orgWriter{w: w}
+}
+
+func (o *orgWriter) WriteResult(r *Result) error {
+	title := r.Title
+	if title == "" {
+		title = r.URL
+	}
+
+	var sb strings.Builder
+	sb.WriteString("* [[" + orgURLEscaper.Replace(sanitizeText(r.URL)) + "][" + orgTextEscaper.Replace(sanitizeText(title)) + "]]\n")
+	sb.WriteString("  :PROPERTIES:\n")
+	if !r.LastVisit.IsZero() {
+		// Inactive timestamps do not clutter the agenda
+		sb.WriteString("  :LAST_VISITED: " + r.LastVisit.Local().Format("[2006-01-02 Mon 15:04]") + "\n")
+	}
+	sb.WriteString("  :VISITS: " + strconv.FormatInt(r.VisitCount, 10) + "\n")
+	sb.WriteString("  :END:\n")
+
+	_, err := io.WriteString(o.w, sb.String())
+	return err
+}
+
+func (o *orgWriter) Flush() error {
+	return nil
+}
+
+var (
+	orgURLEscaper  = strings.NewReplacer("[", "%5B", "]", "%5D", " ", "%20")
+	orgTextEscaper = strings.NewReplacer("[", "{", "]", "}", "\n", " ", "\r", " ")
+)
+
+// Writes each result by executing a user supplied text/template,
+// followed by a newline
+type templateWriter struct {
+	w    io.Writer
+	tmpl *template.Template
+}
+
+// Parses format as a template over Result. The escapes \t, \n and \\ are
+// interpreted so tabs and newlines can be given on the command line.
+func newTemplateWriter(w io.Writer, format string) (*templateWriter, error) {
+	tmpl, err := template.New("format").Parse(templateEscaper.Replace(format))
+	if err != nil {
+		return nil, fmt.Errorf("invalid format: %s", err)
+	}
+
+	return &templateWriter{w: w, tmpl: tmpl}, nil
+}
+
+func (t *templateWriter) WriteResult(r *Result) error {
+	// Tabs and newlines of the output come from the template only
+	clean := *r
+	clean.URL = sanitizeText(r.URL)
+	clean.Title = sanitizeText(r.Title)
+	clean.Description = sanitizeText(r.Description)
+	if err := t.tmpl.Execute(t.w, &clean); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(t.w, "\n")
+	return err
+}
+
+func (t *templateWriter) Flush() error {
+	return nil
+}
+
+var templateEscaper = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n")
+
+// Parses a comma separated list of column names
+func parseColumns(s string) ([]string, error) {
+	var columns []string
+	for _, col := range strings.Split(s, ",") {
+		col = strings.ToLower(strings.TrimSpace(col))
+		if col == "" {
+			continue
+		}
+
+		known := false
+		for _, c := range allColumns {
+			if c == col {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown column %q (available: %s)", col, strings.Join(allColumns, ", "))
+		}
+
+		columns = append(columns, col)
+	}
+
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no columns selected")
+	}
+
+	return columns, nil
+}
+
+// A result as sent to programs talking JSON to ffs
+type jsonResult struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	VisitCount  int64  `json:"visit_count"`
+	Frecency    int64  `json:"frecency"`
+	// RFC3339 in UTC, empty if never visited
+	LastVisit string `json:"last_visit,omitempty"`
+}
+
+// Converts r for JSON output
+func newJSONResult(r *Result) jsonResult {
+	res := jsonResult{
+		URL:         r.URL,
+		Title:       r.Title,
+		Description: r.Description,
+		VisitCount:  r.VisitCount,
+		Frecency:    r.Frecency,
+	}
+	if !r.LastVisit.IsZero() {
+		res.LastVisit = r.LastVisit.UTC().Format(time.RFC3339)
+	}
+
+	return res
+}
+
+// Returns the values of the given columns of r
+func columnValues(r *Result, columns []string) []string {
+	values := make([]string, len(columns))
+	for i, col := range columns {
+		switch col {
+		case "url":
+			values[i] = r.URL
+		case "title":
+			values[i] = r.Title
+		case "date":
+			values[i] = formatTime(r.LastVisit)
+		case "visits":
+			values[i] = strconv.FormatInt(r.VisitCount, 10)
+		case "frecency":
+			values[i] = strconv.FormatInt(r.Frecency, 10)
+		}
+	}
+
+	return values
+}
+
+// Returns s safe to print as a field that may span lines, like those of CSV
+// which are quoted: only its newlines are kept
+func sanitizeField(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = sanitizeText(line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Returns s safe to print as part of a line: tabs and line breaks become
+// spaces, other control characters and the bidi controls, which could
+// rewrite what a terminal shows, are escaped like \x1b
+func sanitizeText(s string) string {
+	if !strings.ContainsFunc(s, isUnsafeRune) {
+		return s
+	}
+
+	var sb strings.Builder
+	for _, c := range s {
+		switch {
+		case c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f':
+			sb.WriteByte(' ')
+		case c < 0x80 && isUnsafeRune(c):
+			fmt.Fprintf(&sb, "\\x%02x", c)
+		case isUnsafeRune(c):
+			fmt.Fprintf(&sb, "\\u%04x", c)
+		default:
+			sb.WriteRune(c)
+		}
+	}
+
+	return sb.String()
+}
+
+// Reports whether c is a control character, or one that changes the
+// direction of the text after it
+func isUnsafeRune(c rune) bool {
+	return unicode.IsControl(c) || (c >= 0x202a && c <= 0x202e) || (c >= 0x2066 && c <= 0x2069)
+}
+
+// How the date column is formatted, "rfc3339" or "relative"
+var dateFormat = "rfc3339"
+
+// Formats a visit time according to dateFormat, or an empty string if unknown
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	if dateFormat == "relative" {
+		return relativeTime(t, time.Now())
+	}
+
+	return t.Local().Format(time.RFC3339)
+}
+
+// Formats t relative to now, e.g. "3 days ago"
+func relativeTime(t, now time.Time) string {
+	d := now.Sub(t)
+	if d < 0 {
+		return "in the future"
+	}
+
+	units := []struct {
+		name string
+		size time.Duration
+	}{
+		{"year", 365 * 24 * time.Hour},
+		{"month", 30 * 24 * time.Hour},
+		{"week", 7 * 24 * time.Hour},
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+	for _, u := range units {
+		if n := int(d / u.size); n > 0 {
+			if n == 1 {
+				return "1 " + u.name + " ago"
+			}
+			return fmt.Sprintf("%d %ss ago", n, u.name)
+		}
+	}
+
+	return "just now"
+}
@@ -0,0 +1,35 @@
+//go:build linux
+
+package main
+
+import (
+	"io"
+	"strings"
+)
+
+// Writes results as grep-like source:title:url lines, e.g. for vim's grepprg
+type grepWriter struct {
+	w      io.Writer
+	source string
+	sep    string
+	hl     *highlighter
+}
+
+// Creates a new grep writer, source names where results come from and sep
+// separates the fields. hl may be nil to disable highlighting.
+func newGrepWriter(w io.Writer, source, sep string, hl *highlighter) *grepWriter {
+	return &grepWriter{w: w, source: source, sep: sep, hl: hl}
+}
+
+func (g *grepWriter) WriteResult(r *Result) error {
+	// One line per result, whatever is in the title
+	title := strings.Join(strings.Fields(sanitizeText(r.Title)), " ")
+	line := g.source + g.sep + g.hl.Highlight(title) + g.sep + g.hl.Highlight(sanitizeText(r.URL)) + "\n"
+
+	_, err := io.WriteString(g.w, line)
+	return err
+}
+
+func (g *grepWriter) Flush() error {
+	return nil
+}
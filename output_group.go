@@ -0,0 +1,78 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// Writes results grouped by their host, each group headed by the host and
+// its number of results. Groups are ordered by their first result, so
+// results are buffered until Flush.
+type groupWriter struct {
+	w       io.Writer
+	columns []string
+	hl      *highlighter
+	hosts   []string
+	groups  map[string][]*Result
+}
+
+// Creates a new group writer, hl may be nil to disable highlighting
+func newGroupWriter(w io.Writer, columns []string, hl *highlighter) *groupWriter {
+	return &groupWriter{w: w, columns: columns, hl: hl, groups: make(map[string][]*Result)}
+}
+
+func (g *groupWriter) WriteResult(r *Result) error {
+	host := resultHost(r.URL)
+	if _, ok := g.groups[host]; !ok {
+		g.hosts = append(g.hosts, host)
+	}
+	g.groups[host] = append(g.groups[host], r)
+
+	return nil
+}
+
+func (g *groupWriter) Flush() error {
+	for _, host := range g.hosts {
+		results := g.groups[host]
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%s (%d)\n", g.hl.Highlight(host), len(results))
+		for _, r := range results {
+			values := columnValues(r, g.columns)
+			for i, col := range g.columns {
+				values[i] = sanitizeText(values[i])
+				if col == "url" || col == "title" {
+					values[i] = g.hl.Highlight(values[i])
+				}
+			}
+			sb.WriteString("  " + strings.Join(values, "\t") + "\n")
+		}
+
+		if _, err := io.WriteString(g.w, sb.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the host of rawURL, or its scheme for URLs without one (e.g. file:)
+func resultHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "(invalid)"
+	}
+
+	if host := u.Hostname(); host != "" {
+		return host
+	}
+	if u.Scheme != "" {
+		return u.Scheme + ":"
+	}
+
+	return "(none)"
+}
@@ -0,0 +1,152 @@
+//go:build linux
+
+package main
+
+import (
+	"html/template"
+	"io"
+	"time"
+)
+
+// Writes results as a single self-contained HTML report
+type htmlWriter struct {
+	w          io.Writer
+	query      string
+	columns    []string
+	icons      *faviconStore
+	headerDone bool
+}
+
+// Creates a new HTML writer, icons may be nil if no favicons are available
+func newHTMLWriter(w io.Writer, query string, columns []string, icons *faviconStore) *htmlWriter {
+	return &htmlWriter{w: w, query: query, columns: columns, icons: icons}
+}
+
+// A single cell of the report table
+type htmlCell struct {
+	Column  string
+	Value   string
+	Link    string
+	Icon    template.URL
+	SortKey string
+}
+
+func (h *htmlWriter) WriteResult(r *Result) error {
+	if err := h.writeHeader(); err != nil {
+		return err
+	}
+
+	var icon template.URL
+	if h.icons != nil {
+		// The data URI is generated by us and safe to embed
+		icon = template.URL(h.icons.DataURI(r.URL))
+	}
+
+	values := columnValues(r, h.columns)
+	cells := make([]htmlCell, len(h.columns))
+	for i, col := range h.columns {
+		cells[i] = htmlCell{Column: col, Value: values[i], SortKey: values[i]}
+		switch col {
+		case "date":
+			// Relative dates do not sort, always sort by the timestamp
+			cells[i].SortKey = r.LastVisit.Format(time.RFC3339)
+		case "url":
+			cells[i].Link = r.URL
+		case "title":
+			cells[i].Link = r.URL
+			cells[i].Icon = icon
+			if cells[i].Value == "" {
+				cells[i].Value = r.URL
+			}
+		}
+	}
+
+	return htmlTemplate.ExecuteTemplate(h.w, "row", cells)
+}
+
+func (h *htmlWriter) Flush() error {
+	if err := h.writeHeader(); err != nil {
+		return err
+	}
+
+	return htmlTemplate.ExecuteTemplate(h.w, "footer", nil)
+}
+
+// Writes the document head and table header once
+func (h *htmlWriter) writeHeader() error {
+	if h.headerDone {
+		return nil
+	}
+	h.headerDone = true
+
+	return htmlTemplate.ExecuteTemplate(h.w, "header", map[string]any{
+		"Query":     h.query,
+		"Columns":   h.columns,
+		"Generated": time.Now().Format(time.RFC1123),
+	})
+}
+
+var htmlTemplate = template.Must(template.New("html").Parse(`
+{{- define "header" -}}
+<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>ffs: {{.Query}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em; color: #222; background: #fafafa; }
+h1 { font-size: 1.3em; }
+p.meta { color: #666; font-size: .9em; }
+table { border-collapse: collapse; width: 100%; background: #fff; }
+th, td { text-align: left; padding: .4em .6em; border-bottom: 1px solid #e5e5e5; vertical-align: top; }
+th { cursor: pointer; user-select: none; background: #f0f0f0; position: sticky; top: 0; }
+th.asc::after { content: " \25B2"; }
+th.desc::after { content: " \25BC"; }
+tr:hover td { background: #f5f8ff; }
+td.url { word-break: break-all; font-family: monospace; font-size: .9em; }
+td.date { white-space: nowrap; }
+img.icon { width: 16px; height: 16px; vertical-align: middle; margin-right: .4em; }
+a { color: #0645ad; text-decoration: none; }
+a:hover { text-decoration: underline; }
+</style>
+</head>
+<body>
+<h1>Firefox history matching <code>{{.Query}}</code></h1>
+<p class="meta">Generated {{.Generated}}</p>
+<table>
+<thead>
+<tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+</thead>
+<tbody>
+{{end}}
+
+{{- define "row" -}}
+<tr>{{range .}}<td class="{{.Column}}" data-sort="{{.SortKey}}">
+{{- if .Icon}}<img class="icon" src="{{.Icon}}" alt="">{{end}}
+{{- if .Link}}<a href="{{.Link}}">{{.Value}}</a>{{else}}{{.Value}}{{end -}}
+</td>{{end}}</tr>
+{{end}}
+
+{{- define "footer" -}}
+</tbody>
+</table>
+<script>
+document.querySelectorAll("th").forEach(function (th, idx) {
+  th.addEventListener("click", function () {
+    var tbody = th.closest("table").querySelector("tbody");
+    var asc = !th.classList.contains("asc");
+    th.parentNode.querySelectorAll("th").forEach(function (h) { h.classList.remove("asc", "desc"); });
+    th.classList.add(asc ? "asc" : "desc");
+    Array.from(tbody.rows)
+      .sort(function (a, b) {
+        var x = a.cells[idx].dataset.sort, y = b.cells[idx].dataset.sort;
+        return (asc ? 1 : -1) * x.localeCompare(y, undefined, { numeric: true });
+      })
+      .forEach(function (row) { tbody.appendChild(row); });
+  });
+});
+</script>
+</body>
+</html>
+{{end}}`))
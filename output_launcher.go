@@ -0,0 +1,76 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// An item as shown by desktop launcher plugins
+type launcherItem struct {
+	Title    string         `json:"title"`
+	Subtitle string         `json:"subtitle"`
+	Icon     string         `json:"icon,omitempty"`
+	Action   launcherAction `json:"action"`
+}
+
+// What a launcher does when an item is activated
+type launcherAction struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// Writes results as a JSON array of launcher items: the title with the URL
+// as subtitle, the favicon as icon and opening the URL as action. Items are
+// written as they come, so the array never has to fit in memory.
+type launcherWriter struct {
+	w        io.Writer
+	icons    *faviconStore
+	iconsDir string
+	written  bool
+}
+
+// Creates a new launcher writer, icons may be nil to not add icons
+func newLauncherWriter(w io.Writer, icons *faviconStore, iconsDir string) *launcherWriter {
+	return &launcherWriter{w: w, icons: icons, iconsDir: iconsDir}
+}
+
+func (l *launcherWriter) WriteResult(r *Result) error {
+	item := launcherItem{
+		Title:    r.Title,
+		Subtitle: r.URL,
+		Action:   launcherAction{Type: "open_url", URL: r.URL},
+	}
+	if item.Title == "" {
+		item.Title = r.URL
+	}
+	if l.icons != nil {
+		item.Icon = l.icons.File(r.URL, l.iconsDir)
+	}
+
+	// Indented as an element of the array
+	data, err := json.MarshalIndent(item, "  ", "  ")
+	if err != nil {
+		return err
+	}
+
+	sep := ",\n  "
+	if !l.written {
+		sep = "[\n  "
+		l.written = true
+	}
+
+	_, err = io.WriteString(l.w, sep+string(data))
+	return err
+}
+
+func (l *launcherWriter) Flush() error {
+	end := "\n]\n"
+	if !l.written {
+		end = "[]\n"
+	}
+
+	_, err := io.WriteString(l.w, end)
+	return err
+}
@@ -0,0 +1,106 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A visit in the shape Promnesia's JSON sources expect
+type promnesiaVisit struct {
+	URL     string           `json:"url"`
+	NormURL string           `json:"norm_url"`
+	DT      string           `json:"dt"`
+	Context string           `json:"context,omitempty"`
+	Locator promnesiaLocator `json:"locator"`
+}
+
+// Where a visit came from
+type promnesiaLocator struct {
+	Title string `json:"title"`
+	Href  string `json:"href"`
+}
+
+// Writes every visit of the results as a JSON line for Promnesia/HPI
+type promnesiaWriter struct {
+	enc    *json.Encoder
+	visits func(url string) ([]time.Time, error)
+}
+
+// Creates a new Promnesia writer, visits returns all visits of a URL
+func newPromnesiaWriter(w io.Writer, visits func(url string) ([]time.Time, error)) *promnesiaWriter {
+	return &promnesiaWriter{enc: json.NewEncoder(w), visits: visits}
+}
+
+func (p *promnesiaWriter) WriteResult(r *Result) error {
+	visits, err := p.visits(r.URL)
+	if err != nil {
+		return err
+	}
+
+	norm := normalizeURL(r.URL)
+	for _, visit := range visits {
+		err := p.enc.Encode(promnesiaVisit{
+			URL:     r.URL,
+			NormURL: norm,
+			DT:      visit.UTC().Format(time.RFC3339),
+			Context: r.Title,
+			Locator: promnesiaLocator{Title: "Firefox history", Href: r.URL},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *promnesiaWriter) Flush() error {
+	return nil
+}
+
+// Normalizes a URL like Promnesia does, so that the same page visited through
+// different URLs is grouped: no scheme, no www., no fragment, no trailing
+// slash, no tracking parameters and sorted query parameters
+func normalizeURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return stripScheme(raw)
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+
+	query := u.Query()
+	for key := range query {
+		if strings.HasPrefix(key, "utm_") {
+			query.Del(key)
+		}
+	}
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var params []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			params = append(params, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		}
+	}
+
+	norm := host + strings.TrimSuffix(u.EscapedPath(), "/")
+	if len(params) > 0 {
+		norm += "?" + strings.Join(params, "&")
+	}
+
+	return norm
+}
@@ -0,0 +1,45 @@
+//go:build linux
+
+package main
+
+import (
+	"io"
+	"strings"
+)
+
+// Writes results as rofi rows: the title is shown, the URL is attached as
+// info (passed back in script mode) and meta (searchable), optionally with
+// the favicon as icon
+type rofiWriter struct {
+	w        io.Writer
+	icons    *faviconStore
+	iconsDir string
+}
+
+// Creates a new rofi writer, icons may be nil to not show icons
+func newRofiWriter(w io.Writer, icons *faviconStore, iconsDir string) *rofiWriter {
+	return &rofiWriter{w: w, icons: icons, iconsDir: iconsDir}
+}
+
+func (r *rofiWriter) WriteResult(res *Result) error {
+	text := strings.Join(strings.Fields(sanitizeText(res.Title)), " ")
+	if text == "" {
+		text = sanitizeText(res.URL)
+	}
+
+	// Row options may not contain the separators, which are control characters
+	url := sanitizeText(res.URL)
+	row := text + "\x00info\x1f" + url + "\x1fmeta\x1f" + url
+	if r.icons != nil {
+		if icon := r.icons.File(res.URL, r.iconsDir); icon != "" {
+			row += "\x1ficon\x1f" + icon
+		}
+	}
+
+	_, err := io.WriteString(r.w, row+"\n")
+	return err
+}
+
+func (r *rofiWriter) Flush() error {
+	return nil
+}
@@ -0,0 +1,86 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Writes results as an RSS 2.0 feed with one item per result
+type rssWriter struct {
+	w          io.Writer
+	query      string
+	link       string
+	headerDone bool
+}
+
+// Creates a new RSS writer, link is the channels link element
+func newRSSWriter(w io.Writer, query, link string) *rssWriter {
+	return &rssWriter{w: w, query: query, link: link}
+}
+
+func (f *rssWriter) WriteResult(r *Result) error {
+	if err := f.writeHeader(); err != nil {
+		return err
+	}
+
+	title := r.Title
+	if title == "" {
+		title = r.URL
+	}
+
+	var sb strings.Builder
+	sb.WriteString("    <item>\n")
+	sb.WriteString("      <title>" + xmlEscape(title) + "</title>\n")
+	sb.WriteString("      <link>" + xmlEscape(r.URL) + "</link>\n")
+	sb.WriteString("      <guid isPermaLink=\"true\">" + xmlEscape(r.URL) + "</guid>\n")
+	if r.Description != "" {
+		sb.WriteString("      <description>" + xmlEscape(r.Description) + "</description>\n")
+	}
+	if !r.LastVisit.IsZero() {
+		sb.WriteString("      <pubDate>" + r.LastVisit.Format(time.RFC1123Z) + "</pubDate>\n")
+	}
+	sb.WriteString("    </item>\n")
+
+	_, err := io.WriteString(f.w, sb.String())
+	return err
+}
+
+func (f *rssWriter) Flush() error {
+	if err := f.writeHeader(); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(f.w, "  </channel>\n</rss>\n")
+	return err
+}
+
+// Writes the XML declaration and channel metadata once
+func (f *rssWriter) writeHeader() error {
+	if f.headerDone {
+		return nil
+	}
+	f.headerDone = true
+
+	_, err := fmt.Fprintf(f.w, `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title>ffs: %s</title>
+    <link>%s</link>
+    <description>Firefox history matching %s</description>
+    <lastBuildDate>%s</lastBuildDate>
+    <generator>ffs</generator>
+`, xmlEscape(f.query), xmlEscape(f.link), xmlEscape(f.query), time.Now().Format(time.RFC1123Z))
+	return err
+}
+
+// Escapes s for use as XML character data
+func xmlEscape(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
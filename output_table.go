@@ -0,0 +1,124 @@
+//go:build linux
+
+package main
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Space between table columns
+	tableGap = "  "
+	// Truncated columns are never made narrower than this
+	tableMinWidth = 12
+)
+
+// Writes results as an aligned table. Since the column widths depend on
+// all rows, results are buffered until Flush.
+type tableWriter struct {
+	w       io.Writer
+	columns []string
+	width   int
+	hl      *highlighter
+	rows    [][]string
+}
+
+// Creates a new table writer. If width is > 0, the url and title columns
+// are truncated so rows fit into width. hl may be nil to disable highlighting.
+func newTableWriter(w io.Writer, columns []string, width int, hl *highlighter) *tableWriter {
+	return &tableWriter{w: w, columns: columns, width: width, hl: hl}
+}
+
+func (t *tableWriter) WriteResult(r *Result) error {
+	values := columnValues(r, t.columns)
+	for i, value := range values {
+		// Keep every row on a single line
+		values[i] = strings.Join(strings.Fields(sanitizeText(value)), " ")
+	}
+
+	t.rows = append(t.rows, values)
+	return nil
+}
+
+func (t *tableWriter) Flush() error {
+	header := make([]string, len(t.columns))
+	for i, col := range t.columns {
+		header[i] = strings.ToUpper(col)
+	}
+
+	widths := make([]int, len(t.columns))
+	for _, row := range append([][]string{header}, t.rows...) {
+		for i, value := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(value))
+		}
+	}
+	t.fitWidths(widths)
+
+	var sb strings.Builder
+	for n, row := range append([][]string{header}, t.rows...) {
+		sb.Reset()
+		for i, value := range row {
+			value = truncate(value, widths[i])
+			pad := widths[i] - utf8.RuneCountInString(value)
+
+			col := t.columns[i]
+			if n > 0 && (col == "url" || col == "title") {
+				value = t.hl.Highlight(value)
+			}
+
+			sb.WriteString(value)
+			// No trailing whitespace after the last column
+			if i < len(row)-1 {
+				sb.WriteString(strings.Repeat(" ", pad) + tableGap)
+			}
+		}
+		sb.WriteString("\n")
+
+		if _, err := io.WriteString(t.w, sb.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Shrinks the widest url or title column until the table fits t.width
+func (t *tableWriter) fitWidths(widths []int) {
+	if t.width <= 0 {
+		return
+	}
+
+	total := func() int {
+		sum := len(tableGap) * (len(widths) - 1)
+		for _, w := range widths {
+			sum += w
+		}
+		return sum
+	}
+
+	for total() > t.width {
+		widest := -1
+		for i, col := range t.columns {
+			if (col == "url" || col == "title") && widths[i] > tableMinWidth && (widest < 0 || widths[i] > widths[widest]) {
+				widest = i
+			}
+		}
+		if widest < 0 {
+			return
+		}
+
+		widths[widest] = max(tableMinWidth, widths[widest]-(total()-t.width))
+	}
+}
+
+// Shortens s to at most width runes, marking cuts with an ellipsis
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+
+	runes := []rune(s)
+	return string(runes[:width-1]) + "…"
+}
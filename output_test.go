@@ -0,0 +1,49 @@
+//go:build linux
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// A title trying to color the terminal and split its result
+var unsafeResult = Result{URL: "https://example.org/", Title: "\x1b[31mred\tand\nsplit\\ok‮"}
+
+func TestWritersEscapeControlCharacters(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(sb *strings.Builder) (resultWriter, error)
+		want  string
+	}{
+		{"tsv", func(sb *strings.Builder) (resultWriter, error) {
+			return newTSVWriter(sb, []string{"url", "title"}, false), nil
+		}, "https://example.org/\t\\x1b[31mred\\tand\\nsplit\\\\ok\\u202e\n"},
+		{"format", func(sb *strings.Builder) (resultWriter, error) {
+			return newTemplateWriter(sb, "{{.Title}}")
+		}, "\\x1b[31mred and split\\ok\\u202e\n"},
+		// Quoted fields may span lines
+		{"csv", func(sb *strings.Builder) (resultWriter, error) {
+			return newCSVWriter(sb, []string{"url", "title"}, false), nil
+		}, "https://example.org/,\"\\x1b[31mred and\nsplit\\ok\\u202e\"\n"},
+	}
+
+	for _, tt := range tests {
+		var sb strings.Builder
+		w, err := tt.write(&sb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := unsafeResult
+		if err := w.WriteResult(&r); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		if sb.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, sb.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Passes output through a pager once it no longer fits on the terminal.
+// Output is held back until it is longer than the terminal, short output
+// is written to w directly when closing.
+type pager struct {
+	w     *os.File
+	rows  int
+	buf   bytes.Buffer
+	lines int
+	cmd   *exec.Cmd
+	in    io.WriteCloser
+	// Set if no pager could be started, output goes to w directly
+	direct bool
+	// Set once the pager was quit, further output is discarded
+	quit bool
+}
+
+// Creates a pager writing to the terminal w with rows rows. With rows 0,
+// the pager is started for any output.
+func newPager(w *os.File, rows int) *pager {
+	return &pager{w: w, rows: rows}
+}
+
+func (p *pager) Write(b []byte) (int, error) {
+	switch {
+	case p.quit:
+		return len(b), nil
+	case p.direct:
+		return p.w.Write(b)
+	case p.in != nil:
+		if _, err := p.in.Write(b); err != nil {
+			// Most likely the pager was quit before reading everything
+			p.quit = true
+		}
+		return len(b), nil
+	}
+
+	p.buf.Write(b)
+	p.lines += bytes.Count(b, []byte("\n"))
+
+	// Leave a row for the prompt
+	if p.lines < p.rows-1 {
+		return len(b), nil
+	}
+
+	if err := p.start(); err != nil {
+		// Without a pager, show everything anyway
+		p.direct = true
+		if _, err := p.w.Write(p.buf.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+	p.buf.Reset()
+
+	return len(b), nil
+}
+
+// Starts $PAGER, or less, and hands it what was held back
+func (p *pager) start() error {
+	args := strings.Fields(os.Getenv("PAGER"))
+	if len(args) == 0 {
+		args = []string{"less"}
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = p.w
+	cmd.Stderr = os.Stderr
+	// Like git: keep colors, quit if the output fits and do not clear the screen
+	if os.Getenv("LESS") == "" {
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	p.cmd, p.in = cmd, in
+
+	if _, err := p.in.Write(p.buf.Bytes()); err != nil {
+		p.quit = true
+	}
+
+	return nil
+}
+
+// Writes short output to w, or waits until the pager was quit
+func (p *pager) Close() error {
+	if p.in == nil {
+		_, err := p.w.Write(p.buf.Bytes())
+		p.buf.Reset()
+		return err
+	}
+
+	p.in.Close()
+	// Whatever the pager exits with, the output was shown
+	p.cmd.Wait()
+	return nil
+}
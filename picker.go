@@ -0,0 +1,313 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Returned when the user cancels a picker
+var errPickCanceled = errors.New("canceled")
+
+// Lets the user pick one of results, using fzf if it is installed and a
+// builtin fuzzy finder otherwise. Returns errPickCanceled if nothing was picked.
+func pickResult(results []*Result) (*Result, error) {
+	if len(results) == 0 {
+		return nil, errPickCanceled
+	}
+
+	if fzf, err := exec.LookPath("fzf"); err == nil {
+		return pickWithFzf(fzf, results)
+	}
+
+	return pickBuiltin(results)
+}
+
+// Returns the line shown for r in pickers
+func pickerLine(r *Result) string {
+	if r.Title == "" {
+		return sanitizeText(r.URL)
+	}
+
+	return strings.Join(strings.Fields(sanitizeText(r.Title)), " ") + "  " + sanitizeText(r.URL)
+}
+
+// Picks a result with fzf, the index of every result is passed as a hidden field
+func pickWithFzf(fzf string, results []*Result) (*Result, error) {
+	var input bytes.Buffer
+	for i, r := range results {
+		fmt.Fprintf(&input, "%d\t%s\n", i, pickerLine(r))
+	}
+
+	cmd := exec.Command(fzf, "--delimiter=\t", "--with-nth=2..", "--no-sort", "--prompt=ffs> ")
+	cmd.Stdin = &input
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// fzf exits with 1 if nothing matched and 130 if canceled
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && (exitErr.ExitCode() == 1 || exitErr.ExitCode() == 130) {
+			return nil, errPickCanceled
+		}
+		return nil, fmt.Errorf("fzf failed: %s", err)
+	}
+
+	idx, _, _ := strings.Cut(string(out), "\t")
+	i, err := strconv.Atoi(idx)
+	if err != nil || i < 0 || i >= len(results) {
+		return nil, fmt.Errorf("unexpected fzf output %q", out)
+	}
+
+	return results[i], nil
+}
+
+// A minimal fuzzy finder drawing on the controlling terminal, so it also
+// works when stdout is piped
+type picker struct {
+	tty      *os.File
+	lines    []string
+	input    []rune
+	matches  []int
+	selected int
+	offset   int
+	height   int
+	width    int
+}
+
+// Picks a result with the builtin fuzzy finder
+func pickBuiltin(results []*Result) (*Result, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("interactive mode needs a terminal: %s", err)
+	}
+	defer tty.Close()
+
+	restore, err := makeRaw(tty)
+	if err != nil {
+		return nil, fmt.Errorf("could not set up terminal: %s", err)
+	}
+	defer restore()
+
+	p := &picker{tty: tty}
+	for _, r := range results {
+		p.lines = append(p.lines, pickerLine(r))
+	}
+	p.height, p.width = terminalSize(tty)
+	if p.height <= 0 {
+		p.height, p.width = 24, 80
+	}
+	p.filter()
+
+	// Alternate screen, restored on return
+	io.WriteString(tty, "\x1b[?1049h")
+	defer io.WriteString(tty, "\x1b[?1049l")
+
+	idx, err := p.run()
+	if err != nil {
+		return nil, err
+	}
+
+	return results[idx], nil
+}
+
+// Handles input until a line was picked, returns its index
+func (p *picker) run() (int, error) {
+	in := bufio.NewReader(p.tty)
+	for {
+		p.draw()
+
+		b, err := in.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		switch b {
+		case 3, 4: // Ctrl-C, Ctrl-D
+			return 0, errPickCanceled
+		case 27: // Escape sequences or a lone Esc
+			if in.Buffered() == 0 {
+				return 0, errPickCanceled
+			}
+			seq := make([]byte, in.Buffered())
+			in.Read(seq)
+			switch string(seq) {
+			case "[A", "OA":
+				p.move(-1)
+			case "[B", "OB":
+				p.move(1)
+			case "[5~":
+				p.move(-p.visible())
+			case "[6~":
+				p.move(p.visible())
+			}
+		case 13, 10: // Enter
+			if len(p.matches) > 0 {
+				return p.matches[p.selected], nil
+			}
+		case 16, 11: // Ctrl-P, Ctrl-K
+			p.move(-1)
+		case 14: // Ctrl-N
+			p.move(1)
+		case 127, 8: // Backspace
+			if len(p.input) > 0 {
+				p.input = p.input[:len(p.input)-1]
+				p.filter()
+			}
+		case 21: // Ctrl-U
+			p.input = p.input[:0]
+			p.filter()
+		default:
+			if b < 32 {
+				continue
+			}
+			in.UnreadByte()
+			r, _, err := in.ReadRune()
+			if err != nil {
+				return 0, err
+			}
+			p.input = append(p.input, r)
+			p.filter()
+		}
+	}
+}
+
+// Updates the matches for the current input
+func (p *picker) filter() {
+	terms := strings.Fields(strings.ToLower(string(p.input)))
+
+	p.matches = p.matches[:0]
+	for i, line := range p.lines {
+		lower := strings.ToLower(line)
+		ok := true
+		for _, term := range terms {
+			if !fuzzyMatch(lower, term) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			p.matches = append(p.matches, i)
+		}
+	}
+
+	p.selected, p.offset = 0, 0
+}
+
+// Moves the selection by delta, scrolling if needed
+func (p *picker) move(delta int) {
+	if len(p.matches) == 0 {
+		return
+	}
+
+	p.selected = min(max(p.selected+delta, 0), len(p.matches)-1)
+	if p.selected < p.offset {
+		p.offset = p.selected
+	}
+	if p.selected >= p.offset+p.visible() {
+		p.offset = p.selected - p.visible() + 1
+	}
+}
+
+// Returns the number of lines available for matches
+func (p *picker) visible() int {
+	return max(p.height-2, 1)
+}
+
+func (p *picker) draw() {
+	var sb strings.Builder
+	sb.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&sb, "ffs> %s\r\n", string(p.input))
+	fmt.Fprintf(&sb, "\x1b[2m  %d/%d\x1b[0m\r\n", len(p.matches), len(p.lines))
+
+	end := min(p.offset+p.visible(), len(p.matches))
+	for i := p.offset; i < end; i++ {
+		line := truncate(p.lines[p.matches[i]], max(p.width-2, 1))
+		if i == p.selected {
+			fmt.Fprintf(&sb, "\x1b[7m> %s\x1b[0m\r\n", line)
+		} else {
+			fmt.Fprintf(&sb, "  %s\r\n", line)
+		}
+	}
+
+	// Put the cursor back at the end of the input
+	fmt.Fprintf(&sb, "\x1b[1;%dH", len("ffs> ")+utf8.RuneCountInString(string(p.input))+1)
+	io.WriteString(p.tty, sb.String())
+}
+
+// Reports whether all runes of term appear in s in order
+func fuzzyMatch(s, term string) bool {
+	for _, r := range term {
+		i := strings.IndexRune(s, r)
+		if i < 0 {
+			return false
+		}
+		s = s[i+utf8.RuneLen(r):]
+	}
+
+	return true
+}
+
+// Menus tried for --menu, in order of preference, with the arguments
+// making them read the lines from stdin and print the picked one
+var menuCommands = [][]string{
+	{"fuzzel", "--dmenu", "--prompt=ffs> "},
+	{"wofi", "--dmenu", "--insensitive", "--prompt=ffs"},
+	{"bemenu", "--ignorecase", "--prompt=ffs"},
+}
+
+// Picks a result with a dmenu-like menu, command overrides the detected one
+func pickWithMenu(command string, results []*Result) (*Result, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		for _, menu := range menuCommands {
+			if _, err := exec.LookPath(menu[0]); err == nil {
+				args = menu
+				break
+			}
+		}
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no menu found, install fuzzel, wofi or bemenu or use --menu-command")
+	}
+
+	// Menus only print the picked line, so it is looked up again
+	var input bytes.Buffer
+	byLine := make(map[string]*Result, len(results))
+	for _, r := range results {
+		line := pickerLine(r)
+		if _, ok := byLine[line]; !ok {
+			byLine[line] = r
+		}
+		input.WriteString(line + "\n")
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = &input
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	picked := strings.TrimRight(string(out), "\n")
+	if err != nil || picked == "" {
+		// Menus exit non-zero without output when canceled
+		var exitErr *exec.ExitError
+		if picked == "" && (err == nil || errors.As(err, &exitErr)) {
+			return nil, errPickCanceled
+		}
+		return nil, fmt.Errorf("%s failed: %s", args[0], err)
+	}
+
+	r, ok := byLine[picked]
+	if !ok {
+		return nil, fmt.Errorf("%s printed an unknown line %q", args[0], picked)
+	}
+
+	return r, nil
+}
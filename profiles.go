@@ -0,0 +1,322 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"unicode/utf16"
+)
+
+// A profile listed in profiles.ini
+type profile struct {
+	Name string `json:"name"`
+	// The absolute path of the profile directory
+	Path string `json:"path"`
+	// Whether it is marked as default in its [Profile] section
+	Default bool `json:"default"`
+}
+
+// An [Install] section of profiles.ini, one per Firefox installation
+type install struct {
+	// The hash of the installation directory, see installHash
+	Hash string
+	// The path of its default profile as written in profiles.ini
+	Default string
+}
+
+// The hash of the [Install] section to take the default profile of, set
+// with --install
+var selectedInstall string
+
+// The name of the profile to read instead of the default one, set with
+// --profile
+var selectedProfile string
+
+// Adds --profile to fs, for the commands that read a profile
+func profileFlag(fs *flag.FlagSet) {
+	fs.StringVar(&selectedProfile, "profile", "", "read the profile called `name` in profiles.ini instead of the default one, see ffs profiles")
+}
+
+// Returns the [Install] sections of the profiles.ini in ffdir that have a
+// default profile, in order
+func listInstalls(ffdir string) ([]install, error) {
+	iniFh, err := os.Open(filepath.Join(ffdir, "profiles.ini"))
+	if err != nil {
+		return nil, fmt.Errorf("could not open profiles.ini: %s", err)
+	}
+	defer iniFh.Close()
+
+	var (
+		installs []install
+		current  *install
+	)
+	scanner := bufio.NewScanner(iniFh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			current = nil
+			if hash, ok := strings.CutPrefix(line, "[Install"); ok {
+				installs = append(installs, install{Hash: strings.TrimSuffix(hash, "]")})
+				current = &installs[len(installs)-1]
+			}
+			continue
+		}
+
+		if value, ok := strings.CutPrefix(line, "Default="); ok && current != nil {
+			current.Default = value
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning profiles.ini: %s", err)
+	}
+
+	// Sections without a default profile are of no use
+	var withDefault []install
+	for _, in := range installs {
+		if in.Default != "" {
+			withDefault = append(withDefault, in)
+		}
+	}
+
+	return withDefault, nil
+}
+
+// Returns the hash of the [Install] section of the firefox in $PATH
+func firefoxInstallHash() (string, error) {
+	path, err := exec.LookPath("firefox")
+	if err != nil {
+		return "", err
+	}
+
+	// Usually a link to the binary in the installation directory
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+
+	return installHash(filepath.Dir(path)), nil
+}
+
+// Returns the hash Firefox names the [Install] section of the installation
+// in dir after, the CityHash64 of the UTF-16 path
+func installHash(dir string) string {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(dir)) {
+		b = binary.LittleEndian.AppendUint16(b, c)
+	}
+
+	return fmt.Sprintf("%X", cityHash64(b))
+}
+
+// Returns the profiles listed in the profiles.ini in ffdir, in order
+func listProfiles(ffdir string) ([]profile, error) {
+	iniFh, err := os.Open(filepath.Join(ffdir, "profiles.ini"))
+	if err != nil {
+		return nil, fmt.Errorf("could not open profiles.ini: %s", err)
+	}
+	defer iniFh.Close()
+
+	var (
+		profiles []profile
+		current  *profile
+		relative bool
+	)
+	// Completes the profile of the section that just ended
+	finish := func() {
+		if current != nil && current.Path != "" {
+			if relative {
+				current.Path = filepath.Join(ffdir, current.Path)
+			}
+			profiles = append(profiles, *current)
+		}
+		current = nil
+	}
+
+	scanner := bufio.NewScanner(iniFh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			finish()
+			if strings.HasPrefix(line, "[Profile") {
+				current, relative = &profile{}, true
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Name":
+			current.Name = value
+		case "Path":
+			current.Path = value
+		case "IsRelative":
+			relative = value == "1"
+		case "Default":
+			current.Default = value == "1"
+		}
+	}
+	finish()
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning profiles.ini: %s", err)
+	}
+
+	return profiles, nil
+}
+
+// Returns the directory of the profile called name in the profiles.ini in
+// ffdir
+func namedProfile(ffdir, name string) (string, error) {
+	profiles, err := listProfiles(ffdir)
+	if err != nil {
+		return "", err
+	}
+
+	names := make([]string, len(profiles))
+	for i, p := range profiles {
+		if p.Name == name {
+			return p.Path, nil
+		}
+		names[i] = p.Name
+	}
+
+	return "", fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// Returns the directory of the profile marked as default in the profiles.ini
+// in ffdir, or of the only profile if none is
+func defaultProfile(ffdir string) (string, error) {
+	profiles, err := listProfiles(ffdir)
+	if err != nil {
+		return "", err
+	}
+
+	for _, p := range profiles {
+		if p.Default {
+			return p.Path, nil
+		}
+	}
+	if len(profiles) == 1 {
+		return profiles[0].Path, nil
+	}
+
+	return "", fmt.Errorf("could not find default profile in %d profiles", len(profiles))
+}
+
+// Returns the process ID of the Firefox that has profileDir open, 0 if none
+// does. Firefox holds a lock on .parentlock while it runs, which is gone
+// when it crashed, unlike the lock symlink, which is only used if the lock
+// cannot be tested.
+func firefoxPID(profileDir string) int {
+	fh, err := os.Open(filepath.Join(profileDir, ".parentlock"))
+	if err == nil {
+		defer fh.Close()
+		lock := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: io.SeekStart}
+		if err := syscall.FcntlFlock(fh.Fd(), syscall.F_GETLK, &lock); err == nil {
+			if lock.Type == syscall.F_UNLCK {
+				return 0
+			}
+			return int(lock.Pid)
+		}
+	}
+
+	// The symlink points to "<ip>:+<pid>"
+	target, err := os.Readlink(filepath.Join(profileDir, "lock"))
+	if err != nil {
+		return 0
+	}
+	_, pid, _ := strings.Cut(target, ":+")
+	n, err := strconv.Atoi(pid)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	if err := syscall.Kill(n, 0); err != nil && err != syscall.EPERM {
+		return 0
+	}
+
+	return n
+}
+
+// Returns the flag set of the profiles subcommand
+func profilesFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("profiles", flag.ExitOnError)
+	fs.StringVar(&selectedInstall, "install", "", "mark the default profile of the Firefox installation with the `hash` of its [Install<hash>] section in profiles.ini")
+	profileFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: ffs profiles [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Lists the profiles of profiles.ini with the time their history was last
+// written to, marking the one ffs reads with *
+func runProfiles(args []string) {
+	fs := profilesFlagSet()
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		os.Exit(exitError)
+	}
+	if len(args) > 0 {
+		fs.Usage()
+		os.Exit(exitError)
+	}
+
+	ffdir, err := firefoxDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitError)
+	}
+	profiles, err := listProfiles(ffdir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitError)
+	}
+
+	// An unknown --profile is an error, a profiles.ini without a default
+	// profile is not
+	searched, err := getFirefoxProfileDir()
+	if err != nil && selectedProfile != "" {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitError)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, p := range profiles {
+		marker := " "
+		if resolved, err := filepath.EvalSymlinks(p.Path); err == nil && resolved == searched {
+			marker = "*"
+		}
+
+		// Firefox writes to the history whenever the profile is used
+		modified := "-"
+		if info, err := os.Stat(filepath.Join(p.Path, "places.sqlite")); err == nil {
+			modified = info.ModTime().Local().Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(tw, "%s %s\t%s\t%s\n", marker, p.Name, p.Path, modified)
+	}
+	if err := tw.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitError)
+	}
+}
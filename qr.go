@@ -0,0 +1,38 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Prints s as a QR code made of half blocks, two modules per character
+// so that it fits on a terminal. With color, the code is drawn black on
+// white whatever the terminal colors are, otherwise the blocks are the
+// light modules as on a dark terminal.
+func printQRCode(w io.Writer, s string, color bool) error {
+	q, err := qrcode.New(s, qrcode.Low)
+	if err != nil {
+		return fmt.Errorf("could not create QR code: %s", err)
+	}
+
+	code := q.ToSmallString(color)
+	if color {
+		lines := strings.Split(strings.TrimSuffix(code, "\n"), "\n")
+		for i, line := range lines {
+			lines[i] = "\x1b[30;107m" + line + colorReset
+		}
+		code = strings.Join(lines, "\n") + "\n"
+	}
+
+	if _, err := io.WriteString(w, code); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, s)
+	return err
+}
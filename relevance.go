@@ -0,0 +1,101 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Half-life of the recency factor of the relevance score
+const relevanceHalfLife = 30 * 24 * time.Hour
+
+// Weights of the factors of the relevance score. Every factor is raised to
+// its weight, so 0 ignores a factor and larger values let it dominate.
+type relevanceWeights struct {
+	Match    float64
+	Frecency float64
+	Recency  float64
+}
+
+var defaultRelevanceWeights = relevanceWeights{Match: 1, Frecency: 1, Recency: 1}
+
+// Scores a history entry as match quality × frecency × recency decay
+func relevance(re *regexp.Regexp, w relevanceWeights, url, title, description string, frecency int64, lastVisit time.Time, now time.Time) float64 {
+	return math.Pow(matchQuality(re, url, title, description), w.Match) *
+		math.Pow(math.Log1p(float64(max(frecency, 0)))+1, w.Frecency) *
+		math.Pow(recencyDecay(lastVisit, now), w.Recency)
+}
+
+// Rates how well an entry matches in (0, 1]. Matches in the title beat
+// matches in the URL which beat matches in the description, and a match
+// covering more of the field beats a match in a long string.
+func matchQuality(re *regexp.Regexp, url, title, description string) float64 {
+	if re == nil {
+		return 1
+	}
+
+	fields := []struct {
+		value string
+		score float64
+	}{
+		{title, 1},
+		{stripScheme(url), 0.8},
+		{description, 0.5},
+	}
+
+	best := 0.1
+	for _, f := range fields {
+		loc := re.FindStringIndex(f.value)
+		if loc == nil {
+			continue
+		}
+
+		coverage := float64(loc[1]-loc[0]) / float64(len(f.value))
+		best = max(best, f.score*(0.5+0.5*coverage))
+	}
+
+	return best
+}
+
+// Halves every relevanceHalfLife since the last visit, never visited is 0
+func recencyDecay(lastVisit, now time.Time) float64 {
+	if lastVisit.IsZero() {
+		return 0
+	}
+
+	age := max(now.Sub(lastVisit), 0)
+	return math.Exp2(-float64(age) / float64(relevanceHalfLife))
+}
+
+// Removes the scheme of a URL so it does not count towards match coverage
+func stripScheme(url string) string {
+	if _, rest, ok := strings.Cut(url, "://"); ok {
+		return rest
+	}
+
+	return url
+}
+
+// Parses comma separated match,frecency,recency weights
+func parseRelevanceWeights(s string) (relevanceWeights, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return relevanceWeights{}, fmt.Errorf("relevance weights must be match,frecency,recency, got %q", s)
+	}
+
+	var values [3]float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || v < 0 {
+			return relevanceWeights{}, fmt.Errorf("invalid relevance weight %q", part)
+		}
+		values[i] = v
+	}
+
+	return relevanceWeights{Match: values[0], Frecency: values[1], Recency: values[2]}, nil
+}
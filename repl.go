@@ -0,0 +1,130 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The flags of the repl subcommand
+var replFlags struct {
+	sort    string
+	browser string
+}
+
+// Returns the flag set of the repl subcommand
+func replFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("repl", flag.ExitOnError)
+	profileFlag(fs)
+	fs.StringVar(&replFlags.sort, "sort", "relevance", "initial sort order, change it with :sort")
+	fs.StringVar(&replFlags.browser, "browser", "", "`command` to open URLs with for :open (default $BROWSER or xdg-open)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: ffs repl [flags]\n\n")
+		fmt.Fprintf(os.Stderr, "Reads one query per line. Commands: :open <n>, :sort <order>, :quit\n\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Runs the repl subcommand: reads one query per line and searches a single
+// snapshot of the history, so only the first search pays for copying it
+func runREPL(args []string) {
+	if _, err := parseArgs(replFlagSet(), args); err != nil {
+		os.Exit(exitError)
+	}
+
+	opts := defaultSearchOptions
+	opts.Sort = replFlags.sort
+	if err := opts.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitError)
+	}
+
+	profileDir, err := getFirefoxProfileDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get Mozilla profile directory: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	// The session outlives what an in-place read may, search a copy.
+	// Only taking it can be interrupted.
+	ctx, stop := signalContext()
+	db, cleanup, err := openCachedPlaces(ctx, profileDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", contextError(ctx, err))
+		os.Exit(exitCode(exitError))
+	}
+	defer cleanup()
+	stop()
+
+	tty := isTerminal(os.Stdin) && isTerminal(os.Stdout)
+	color, _ := useColor("auto", tty)
+
+	// The URLs of the last search, for :open
+	var last []string
+
+	in := bufio.NewScanner(os.Stdin)
+	for {
+		if tty {
+			fmt.Fprint(os.Stderr, "ffs> ")
+		}
+		if !in.Scan() {
+			break
+		}
+		line := strings.TrimSpace(in.Text())
+
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch cmd {
+		case "":
+			continue
+		case ":q", ":quit", ":exit":
+			return
+		case ":sort":
+			o := opts
+			o.Sort = strings.TrimSpace(arg)
+			if err := o.validate(); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				continue
+			}
+			opts = o
+			continue
+		case ":open":
+			n, err := strconv.Atoi(strings.TrimSpace(arg))
+			if err != nil || n < 1 || n > len(last) {
+				fmt.Fprintf(os.Stderr, "no result %q\n", arg)
+				continue
+			}
+			if err := openURL(browserCommand(replFlags.browser), last[n-1]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+			}
+			continue
+		}
+
+		var hl *highlighter
+		if color {
+			hl = newHighlighter(line)
+		}
+		out := newPlainWriter(os.Stdout, []string{"url", "title"}, "\n", hl)
+		out.numbered = true
+
+		last = last[:0]
+		err := searchHistory(context.Background(), db, line, opts, func(r *Result) error {
+			last = append(last, r.URL)
+			return out.WriteResult(r)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+	}
+
+	if err := in.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "error reading input: %s\n", err)
+		os.Exit(exitError)
+	}
+}
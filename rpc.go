@@ -0,0 +1,137 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// How long a snapshot of the history is reused between requests
+const rpcSnapshotAge = 30 * time.Second
+
+// Serves editor plugins over JSON-RPC
+type rpcServer struct {
+	profileDir string
+	places     *placesSnapshot
+}
+
+// Returns the flag set of the rpc subcommand
+func rpcFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("rpc", flag.ExitOnError)
+	profileFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: ffs rpc\n\n")
+		fmt.Fprintf(os.Stderr, "Answers JSON-RPC 2.0 requests on stdio, one per line, methods: search, list-profiles, open\n")
+	}
+	return fs
+}
+
+// Runs the rpc subcommand, answering newline-delimited JSON-RPC 2.0
+// requests on stdin/stdout until stdin is closed
+func runRPC(args []string) {
+	if _, err := parseArgs(rpcFlagSet(), args); err != nil {
+		os.Exit(exitError)
+	}
+
+	profileDir, err := getFirefoxProfileDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get Mozilla profile directory: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	s := &rpcServer{profileDir: profileDir, places: newPlacesSnapshot(profileDir, rpcSnapshotAge)}
+	defer s.places.Close()
+
+	if err := serveJSONRPC(os.Stdin, os.Stdout, s.handle); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		s.places.Close()
+		os.Exit(exitError)
+	}
+}
+
+// Answers a request:
+//
+//	search         {"query", "sort", "reverse", "limit"} -> [{"url", "title", ...}]
+//	list-profiles  {} -> [{"name", "path", "default", "searched"}]
+//	open           {"url", "browser"} -> {}
+func (s *rpcServer) handle(method string, params json.RawMessage) (any, error) {
+	switch method {
+	case "search":
+		var p struct {
+			Query   string `json:"query"`
+			Sort    string `json:"sort"`
+			Reverse bool   `json:"reverse"`
+			Limit   int    `json:"limit"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if p.Query == "" {
+			return nil, &rpcError{rpcInvalidParams, "query must not be empty"}
+		}
+
+		opts := defaultSearchOptions
+		if p.Sort != "" {
+			opts.Sort = p.Sort
+		}
+		opts.Reverse = p.Reverse
+		opts.Limit = p.Limit
+		if err := opts.validate(); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+
+		results := []jsonResult{}
+		err := s.places.With(func(db *sql.DB) error {
+			return searchHistory(context.Background(), db, p.Query, opts, func(res *Result) error {
+				results = append(results, newJSONResult(res))
+				return nil
+			})
+		})
+
+		return results, err
+	case "list-profiles":
+		ffdir, err := firefoxDir()
+		if err != nil {
+			return nil, err
+		}
+		profiles, err := listProfiles(ffdir)
+		if err != nil {
+			return nil, err
+		}
+
+		type listedProfile struct {
+			profile
+			// Whether ffs searches this profile
+			Searched bool `json:"searched"`
+		}
+		listed := make([]listedProfile, len(profiles))
+		for i, p := range profiles {
+			resolved, _ := filepath.EvalSymlinks(p.Path)
+			listed[i] = listedProfile{p, resolved == s.profileDir}
+		}
+
+		return listed, nil
+	case "open":
+		var p struct {
+			URL     string `json:"url"`
+			Browser string `json:"browser"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if p.URL == "" {
+			return nil, &rpcError{rpcInvalidParams, "url must not be empty"}
+		}
+
+		return nil, openURL(browserCommand(p.Browser), p.URL)
+	}
+
+	return nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("unknown method %q", method)}
+}
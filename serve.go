@@ -0,0 +1,347 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// How long a snapshot of the history is reused between requests
+	serveSnapshotAge = 30 * time.Second
+	// How many results are returned unless the request asks for a limit
+	serveDefaultLimit = 100
+	// How long a client may take to read a part of a response
+	serveWriteTimeout = 30 * time.Second
+)
+
+// Serves the history over HTTP
+type server struct {
+	places *placesSnapshot
+	live   *liveHub
+}
+
+// Creates a server of the history in profileDir
+func newServer(profileDir string) *server {
+	places := newPlacesSnapshot(profileDir, serveSnapshotAge)
+	return &server{places: places, live: newLiveHub(places)}
+}
+
+// The flags of the serve subcommand
+var serveFlags struct {
+	listen     string
+	useGRPC    bool
+	mountsFile string
+}
+
+// Returns the flag set of the serve subcommand
+func serveFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	profileFlag(fs)
+	fs.StringVar(&serveFlags.listen, "listen", "127.0.0.1:7070", "`address` to listen on")
+	fs.BoolVar(&serveFlags.useGRPC, "grpc", false, "serve the History service of ffspb/ffs.proto instead of HTTP")
+	fs.StringVar(&serveFlags.mountsFile, "mounts", "", "serve the profiles listed in the JSON `file` under their path prefixes, each with its own bearer token")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: ffs serve [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Runs the serve subcommand, answering read-only history searches over
+// HTTP, or gRPC with --grpc, until interrupted
+func runServe(args []string) {
+	if _, err := parseArgs(serveFlagSet(), args); err != nil {
+		os.Exit(exitError)
+	}
+
+	if serveFlags.mountsFile != "" && serveFlags.useGRPC {
+		fmt.Fprintf(os.Stderr, "--mounts only works with HTTP\n")
+		os.Exit(exitError)
+	}
+
+	// The default profile at /, or the profiles of --mounts
+	var (
+		servers []*server
+		handler http.Handler
+	)
+	if serveFlags.mountsFile != "" {
+		mounts, err := loadServeMounts(serveFlags.mountsFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitError)
+		}
+		handler, servers = mountHandler(mounts)
+	} else {
+		profileDir, err := getFirefoxProfileDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to get Mozilla profile directory: %s\n", err)
+			os.Exit(exitError)
+		}
+
+		s := newServer(profileDir)
+		mux := http.NewServeMux()
+		mux.Handle("/", s.routes())
+		mux.HandleFunc("GET /metrics", handleMetrics)
+		handler, servers = mux, []*server{s}
+	}
+	closeAll := func() {
+		for _, s := range servers {
+			s.places.Close()
+		}
+	}
+	defer closeAll()
+
+	// Stop serving on Ctrl-C, the deferred cleanup removes the snapshot
+	ctx, stop := signalContext()
+	defer stop()
+
+	// Started by systemd, the socket is already listening
+	lis, err := activationListener()
+	if err == nil && lis == nil {
+		lis, err = net.Listen("tcp", serveFlags.listen)
+	}
+	if err == nil {
+		if serveFlags.useGRPC {
+			err = servers[0].serveGRPC(ctx, lis)
+		} else {
+			err = serveHTTP(ctx, lis, handler)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		closeAll()
+		os.Exit(exitError)
+	}
+}
+
+// Serves handler on lis until ctx is done
+func serveHTTP(ctx context.Context, lis net.Listener, handler http.Handler) error {
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		// Ends live searches on shutdown
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	fmt.Fprintf(os.Stderr, "listening on http://%s\n", lis.Addr())
+	if err := srv.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// Returns the handler for all endpoints
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /search", s.handleSearch)
+	mux.HandleFunc("GET /live", s.handleLive)
+	mux.HandleFunc("GET /suggest", s.handleSuggest)
+	mux.HandleFunc("GET /opensearch.xml", s.handleOpenSearch)
+	mux.Handle("GET /", webUI())
+
+	return mux
+}
+
+// Searches the history, parameters:
+//
+//	q        the query (required)
+//	browser  only "firefox"
+//	format   json (default), csv, tsv, yaml or rss
+//	sort     any --sort order, date by default
+//	reverse  flips the order if "true"
+//	limit    at most this many results, 100 by default, 0 for all
+//	offset   skips this many results, for paging
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query, opts, offset, err := parseSearchParams(params)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	format := params.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	contentType, ok := serveContentTypes[format]
+	if !ok {
+		httpError(w, http.StatusBadRequest, fmt.Sprintf("unknown format %q (available: csv, json, rss, tsv, yaml)", format))
+		return
+	}
+
+	// Results are written as they are read, so even all of a huge history
+	// is never held in memory. Once the first is written, an error can
+	// only cut the response short. The snapshot is not locked meanwhile,
+	// a client that stops reading only keeps its own search waiting, until
+	// serveWriteTimeout.
+	var (
+		out     resultWriter
+		matches int
+	)
+	begin := func() {
+		w.Header().Set("Content-Type", contentType)
+		rc := http.NewResponseController(w)
+		out = newServeWriter(deadlineWriter{w, rc.SetWriteDeadline, serveWriteTimeout}, format, query)
+	}
+
+	start := time.Now()
+	err = s.places.With(func(db *sql.DB) error {
+		skipped := 0
+		return searchHistory(r.Context(), db, query, opts, func(res *Result) error {
+			if skipped < offset {
+				skipped++
+				return nil
+			}
+			if out == nil {
+				begin()
+			}
+			matches++
+			return out.WriteResult(res)
+		})
+	})
+	metrics.observeQuery("search", start, matches, err)
+	if err != nil {
+		if out == nil {
+			httpError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	if out == nil {
+		begin()
+	}
+	out.Flush()
+}
+
+// Returns the query, options and offset of a search request. With a
+// limit, opts.Limit includes the offset.
+func parseSearchParams(params url.Values) (string, searchOptions, int, error) {
+	query := params.Get("q")
+	if query == "" {
+		return "", searchOptions{}, 0, fmt.Errorf("missing query parameter q")
+	}
+
+	if err := validateGlob(query); err != nil {
+		return "", searchOptions{}, 0, err
+	}
+
+	if browser := params.Get("browser"); browser != "" && browser != "firefox" {
+		return "", searchOptions{}, 0, fmt.Errorf("unknown browser %q (available: firefox)", browser)
+	}
+
+	opts := defaultSearchOptions
+	if sort := params.Get("sort"); sort != "" {
+		opts.Sort = sort
+	}
+	opts.Limit = serveDefaultLimit
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return "", searchOptions{}, 0, fmt.Errorf("invalid limit %q", limit)
+		}
+		opts.Limit = n
+	}
+	opts.Reverse = params.Get("reverse") == "true"
+	if err := opts.validate(); err != nil {
+		return "", searchOptions{}, 0, err
+	}
+
+	offset := 0
+	if o := params.Get("offset"); o != "" {
+		n, err := strconv.Atoi(o)
+		if err != nil || n < 0 {
+			return "", searchOptions{}, 0, fmt.Errorf("invalid offset %q", o)
+		}
+		offset = n
+	}
+	if opts.Limit > 0 {
+		opts.Limit += offset
+	}
+
+	return query, opts, offset, nil
+}
+
+// Content types of the formats /search supports
+var serveContentTypes = map[string]string{
+	"json": "application/json",
+	"csv":  "text/csv; charset=utf-8",
+	"tsv":  "text/tab-separated-values; charset=utf-8",
+	"yaml": "application/yaml",
+	"rss":  "application/rss+xml",
+}
+
+// Returns a writer of results to w in one of the serveContentTypes formats
+func newServeWriter(w io.Writer, format, query string) resultWriter {
+	switch format {
+	case "csv":
+		return newCSVWriter(w, defaultColumns, true)
+	case "tsv":
+		return newTSVWriter(w, defaultColumns, true)
+	case "yaml":
+		return newYAMLWriter(w, defaultColumns)
+	case "rss":
+		return newRSSWriter(w, query, projectURL)
+	default:
+		return &jsonResultsWriter{w: w}
+	}
+}
+
+// Writes results as {"results": [...]}, one at a time
+type jsonResultsWriter struct {
+	w       io.Writer
+	written bool
+}
+
+func (j *jsonResultsWriter) WriteResult(r *Result) error {
+	data, err := json.Marshal(newJSONResult(r))
+	if err != nil {
+		return err
+	}
+
+	sep := ","
+	if !j.written {
+		sep = `{"results":[`
+		j.written = true
+	}
+
+	_, err = io.WriteString(j.w, sep+string(data))
+	return err
+}
+
+func (j *jsonResultsWriter) Flush() error {
+	end := "]}\n"
+	if !j.written {
+		end = `{"results":[]}` + "\n"
+	}
+
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+// Responds with a JSON error
+func httpError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{msg})
+}
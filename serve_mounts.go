@@ -0,0 +1,109 @@
+//go:build linux
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A profile served under a path prefix, see --mounts
+type serveMount struct {
+	// e.g. "/alice"
+	Prefix string `json:"prefix"`
+	// The profile directory, e.g. "/home/alice/.mozilla/firefox/abc.default-release"
+	Profile string `json:"profile"`
+	// The bearer token required for the prefix, none if empty
+	Token string `json:"token"`
+}
+
+// Reads the mounts of a --mounts file, a JSON object like
+//
+//	{"mounts": [{"prefix": "/alice", "profile": "...", "token": "..."}]}
+func loadServeMounts(path string) ([]serveMount, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read mounts: %s", err)
+	}
+
+	var file struct {
+		Mounts []serveMount `json:"mounts"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %s", path, err)
+	}
+	if len(file.Mounts) == 0 {
+		return nil, fmt.Errorf("no mounts in %s", path)
+	}
+
+	// The tokens are secrets
+	if fi, err := os.Stat(path); err == nil && fi.Mode().Perm()&0077 != 0 {
+		fmt.Fprintf(os.Stderr, "warning: %s is accessible by other users, consider chmod 600\n", path)
+	}
+
+	seen := make(map[string]bool)
+	for i, m := range file.Mounts {
+		prefix := strings.TrimSuffix(m.Prefix, "/")
+		if !strings.HasPrefix(m.Prefix, "/") || prefix == "" {
+			return nil, fmt.Errorf("invalid prefix %q, must start with / and not be /", m.Prefix)
+		}
+		if seen[prefix] {
+			return nil, fmt.Errorf("prefix %q is mounted twice", m.Prefix)
+		}
+		seen[prefix] = true
+
+		if _, err := os.Stat(filepath.Join(m.Profile, "places.sqlite")); err != nil {
+			return nil, fmt.Errorf("invalid profile for %s: %s", m.Prefix, err)
+		}
+
+		file.Mounts[i].Prefix = prefix
+	}
+
+	return file.Mounts, nil
+}
+
+// Returns a handler serving each mount under its prefix, and the servers
+// of the mounts. /metrics is served without a token.
+func mountHandler(mounts []serveMount) (http.Handler, []*server) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", handleMetrics)
+
+	var servers []*server
+	for _, m := range mounts {
+		s := newServer(m.Profile)
+		servers = append(servers, s)
+
+		var h http.Handler = s.routes()
+		if m.Token != "" {
+			h = requireToken(m.Token, h)
+		}
+		mux.Handle(m.Prefix+"/", http.StripPrefix(m.Prefix, h))
+	}
+
+	return mux, servers
+}
+
+// Only passes requests with the bearer token on to next. Browsers cannot
+// send headers when opening a page, so the web UI may pass the token as
+// access_token parameter instead.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			got = r.URL.Query().Get("access_token")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ffs"`)
+			httpError(w, http.StatusUnauthorized, "invalid or missing token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,49 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// The signal that interrupted ffs, 0 if none did
+var caughtSignal atomic.Int32
+
+// Returns a context that is done on SIGINT or SIGTERM, so a copy or search
+// in progress stops and its temporary files are removed before exiting.
+// A second signal kills ffs, should it be stuck. The returned func stops
+// catching signals.
+func signalContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			caughtSignal.Store(int32(sig.(syscall.Signal)))
+			signal.Stop(signals)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// Returns the exit code of a failed run, 128 plus the number of the signal
+// that interrupted it like shells report it, or code
+func exitCode(code int) int {
+	if sig := caughtSignal.Load(); sig != 0 {
+		return 128 + int(sig)
+	}
+
+	return code
+}
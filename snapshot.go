@@ -0,0 +1,105 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// A snapshot of the history shared by the long running modes, which is
+// taken again once it is older than maxAge
+type placesSnapshot struct {
+	profileDir string
+	maxAge     time.Duration
+
+	// Only held while the snapshot is taken or replaced, not while it is
+	// searched, so a client that is slow to read does not hold up others
+	mu      sync.Mutex
+	current *snapshotDB
+	taken   time.Time
+}
+
+// A taken snapshot, closed once it was replaced and no call uses it anymore
+type snapshotDB struct {
+	db      *sql.DB
+	cleanup func()
+	users   int
+}
+
+// Creates a snapshot of the places.sqlite in profileDir, which is only
+// taken on first use
+func newPlacesSnapshot(profileDir string, maxAge time.Duration) *placesSnapshot {
+	return &placesSnapshot{profileDir: profileDir, maxAge: maxAge}
+}
+
+// Calls fn with the snapshot, taking a new one first if it is too old.
+// Calls run at the same time, a snapshot replaced while fn runs is only
+// closed once fn returned.
+func (s *placesSnapshot) With(fn func(db *sql.DB) error) error {
+	snap, err := s.acquire()
+	if err != nil {
+		return err
+	}
+	defer s.release(snap)
+
+	return fn(snap.db)
+}
+
+// Returns the snapshot for a call, taking a new one first if it is too old
+func (s *placesSnapshot) acquire() (*snapshotDB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current == nil || time.Since(s.taken) >= s.maxAge {
+		s.replace(nil)
+		db, cleanup, err := openCachedPlaces(context.Background(), s.profileDir)
+		metrics.observeRefresh(err)
+		if err != nil {
+			return nil, err
+		}
+		s.replace(&snapshotDB{db: db, cleanup: cleanup})
+		s.taken = time.Now()
+	}
+
+	s.current.users++
+	return s.current, nil
+}
+
+// Ends a call using snap, closing it if it was replaced meanwhile
+func (s *placesSnapshot) release(snap *snapshotDB) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap.users--
+	if snap.users == 0 && snap != s.current {
+		snap.cleanup()
+	}
+}
+
+// Makes snap the current snapshot, closing the previous one unless a call
+// still uses it. Must be called with mu held.
+func (s *placesSnapshot) replace(snap *snapshotDB) {
+	if s.current != nil && s.current.users == 0 {
+		s.current.cleanup()
+	}
+	s.current = snap
+}
+
+// Marks the snapshot as outdated, so the next call to With takes a new one
+func (s *placesSnapshot) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.taken = time.Time{}
+}
+
+// Closes the snapshot, if any, once no call uses it anymore
+func (s *placesSnapshot) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replace(nil)
+}
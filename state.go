@@ -0,0 +1,80 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// The last query printed with numbered results, so that `ffs open <n>`
+// can run it again
+type lastQuery struct {
+	Query   string        `json:"query"`
+	Options searchOptions `json:"options"`
+	// Whether the bookmarks were searched instead of the history
+	Bookmarks bool `json:"bookmarks,omitempty"`
+}
+
+// Returns the path of the file the last query is kept in,
+// $XDG_STATE_HOME/ffs/last-query.json
+func lastQueryPath() (string, error) {
+	stateDir := os.Getenv("XDG_STATE_HOME")
+	if stateDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("could not get home directory: %s", err)
+		}
+		stateDir = filepath.Join(homeDir, ".local", "state")
+	}
+
+	return filepath.Join(stateDir, "ffs", "last-query.json"), nil
+}
+
+// Remembers q as the last query
+func saveLastQuery(q lastQuery) error {
+	path, err := lastQueryPath()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(q)
+	if err != nil {
+		return fmt.Errorf("could not save last query: %s", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("could not save last query: %s", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("could not save last query: %s", err)
+	}
+
+	return nil
+}
+
+// Returns the last query saved by saveLastQuery
+func loadLastQuery() (lastQuery, error) {
+	var q lastQuery
+
+	path, err := lastQueryPath()
+	if err != nil {
+		return q, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, fmt.Errorf("no previous search, run ffs \"<query>\" first")
+	}
+	if err != nil {
+		return q, fmt.Errorf("could not read last query: %s", err)
+	}
+
+	if err := json.Unmarshal(data, &q); err != nil {
+		return q, fmt.Errorf("could not read last query: %s", err)
+	}
+
+	return q, nil
+}
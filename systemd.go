@@ -0,0 +1,183 @@
+//go:build linux
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The first file descriptor passed by systemd socket activation
+const listenFdsStart = 3
+
+// Returns the socket passed by systemd socket activation, nil if the
+// process was not started that way
+func activationListener() (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+
+	// Not meant for children, like the browser
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(listenFdsStart, "LISTEN_FD_3")
+	defer f.Close()
+
+	lis, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not use socket from systemd: %s", err)
+	}
+
+	return lis, nil
+}
+
+// The user units written by ffs systemd-install
+const (
+	daemonSocketUnit = `[Unit]
+Description=ffs daemon socket
+
+[Socket]
+ListenStream=%t/ffs/daemon.sock
+SocketMode=0600
+DirectoryMode=0700
+
+[Install]
+WantedBy=sockets.target
+`
+	daemonServiceUnit = `[Unit]
+Description=ffs daemon, answering Firefox history searches
+Requires=ffs-daemon.socket
+
+[Service]
+ExecStart=%s daemon
+`
+	serveSocketUnit = `[Unit]
+Description=ffs serve socket
+
+[Socket]
+ListenStream=%s
+
+[Install]
+WantedBy=sockets.target
+`
+	serveServiceUnit = `[Unit]
+Description=ffs serve, the HTTP search API and history explorer
+Requires=ffs-serve.socket
+
+[Service]
+ExecStart=%s serve
+`
+)
+
+// The flags of the systemd-install subcommand
+var systemdInstallFlags struct {
+	serve    bool
+	listen   string
+	noEnable bool
+}
+
+// Returns the flag set of the systemd-install subcommand
+func systemdInstallFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("systemd-install", flag.ExitOnError)
+	fs.BoolVar(&systemdInstallFlags.serve, "serve", false, "also install ffs serve")
+	fs.StringVar(&systemdInstallFlags.listen, "listen", "127.0.0.1:7070", "`address` for ffs serve to listen on")
+	fs.BoolVar(&systemdInstallFlags.noEnable, "no-enable", false, "only write the units, do not enable them")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: ffs systemd-install [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Runs the systemd-install subcommand, writing user units that start
+// ffs daemon (and with --serve, ffs serve) on the first connection
+func runSystemdInstall(args []string) {
+	if _, err := parseArgs(systemdInstallFlagSet(), args); err != nil {
+		os.Exit(exitError)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not get path of ffs: %s\n", err)
+		os.Exit(exitError)
+	}
+	exe, err = filepath.Abs(exe)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not get path of ffs: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not get config directory: %s\n", err)
+		os.Exit(exitError)
+	}
+	unitDir := filepath.Join(configDir, "systemd", "user")
+
+	execPath := systemdQuote(exe)
+	units := map[string]string{
+		"ffs-daemon.socket":  daemonSocketUnit,
+		"ffs-daemon.service": fmt.Sprintf(daemonServiceUnit, execPath),
+	}
+	sockets := []string{"ffs-daemon.socket"}
+	if systemdInstallFlags.serve {
+		units["ffs-serve.socket"] = fmt.Sprintf(serveSocketUnit, systemdInstallFlags.listen)
+		units["ffs-serve.service"] = fmt.Sprintf(serveServiceUnit, execPath)
+		sockets = append(sockets, "ffs-serve.socket")
+	}
+
+	if err := os.MkdirAll(unitDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "could not create %s: %s\n", unitDir, err)
+		os.Exit(exitError)
+	}
+	for _, name := range slices.Sorted(maps.Keys(units)) {
+		path := filepath.Join(unitDir, name)
+		if err := os.WriteFile(path, []byte(units[name]), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "could not write %s: %s\n", path, err)
+			os.Exit(exitError)
+		}
+		fmt.Println(path)
+	}
+
+	if systemdInstallFlags.noEnable {
+		return
+	}
+
+	commands := [][]string{
+		{"systemctl", "--user", "daemon-reload"},
+		append([]string{"systemctl", "--user", "enable", "--now"}, sockets...),
+	}
+	for _, args := range commands {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v failed: %s\n", args, err)
+			os.Exit(exitError)
+		}
+	}
+}
+
+// Quotes s for ExecStart, which splits on spaces and expands % and $
+func systemdQuote(s string) string {
+	s = strings.NewReplacer("%", "%%", "$", "$$").Replace(s)
+	if !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,119 @@
+//go:build linux
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// The flags of the tmux subcommand
+var tmuxFlags struct {
+	open    bool
+	browser string
+	width   string
+	height  string
+}
+
+// Returns the flag set of the tmux subcommand
+func tmuxFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("tmux", flag.ExitOnError)
+	fs.BoolVar(&tmuxFlags.open, "open", false, "open the picked URL instead of pasting it")
+	fs.StringVar(&tmuxFlags.browser, "browser", "", "`command` to open URLs with, %s is replaced by the URL (default $BROWSER or xdg-open)")
+	fs.StringVar(&tmuxFlags.width, "width", "80%", "popup width, in cells or percent")
+	fs.StringVar(&tmuxFlags.height, "height", "60%", "popup height, in cells or percent")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: ffs tmux [flags] [\"<query>\"]\n\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Runs the tmux subcommand: picks a result with ffs -i in a tmux popup and
+// pastes its URL into the current pane or opens it
+func runTmux(args []string) {
+	args, err := parseArgs(tmuxFlagSet(), args)
+	if err != nil {
+		os.Exit(exitError)
+	}
+
+	if os.Getenv("TMUX") == "" {
+		fmt.Fprintf(os.Stderr, "ffs tmux needs to run inside tmux\n")
+		os.Exit(exitError)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not find the ffs binary: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	// The popup has no stdout to read, the pick is passed through a file
+	dir, err := os.MkdirTemp("", "ffs-tmux-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not create temporary directory: %s\n", err)
+		os.Exit(exitError)
+	}
+	defer os.RemoveAll(dir)
+	pickFile := filepath.Join(dir, "pick")
+
+	picker := []string{shellQuote(exe), "-i"}
+	for _, arg := range args {
+		picker = append(picker, shellQuote(arg))
+	}
+	script := strings.Join(picker, " ") + " > " + shellQuote(pickFile)
+
+	cmd := exec.Command("tmux", "display-popup", "-E", "-w", tmuxFlags.width, "-h", tmuxFlags.height, "-T", " ffs ", script)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		// Canceling the picker closes the popup with an error
+		if _, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitNoMatch)
+		}
+		fmt.Fprintf(os.Stderr, "tmux failed: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	data, err := os.ReadFile(pickFile)
+	url := strings.TrimSpace(string(data))
+	if err != nil || url == "" {
+		os.Exit(exitNoMatch)
+	}
+
+	if tmuxFlags.open {
+		if err := openURL(browserCommand(tmuxFlags.browser), url); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitError)
+		}
+		return
+	}
+
+	if err := tmuxPaste(url); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitError)
+	}
+}
+
+// Pastes s into the pane ffs was started from, through a buffer of its own
+// so the user's paste buffers are left alone
+func tmuxPaste(s string) error {
+	const buffer = "ffs"
+
+	if out, err := exec.Command("tmux", "set-buffer", "-b", buffer, "--", s).CombinedOutput(); err != nil {
+		return fmt.Errorf("tmux set-buffer failed: %s %s", err, strings.TrimSpace(string(out)))
+	}
+
+	args := []string{"paste-buffer", "-d", "-b", buffer}
+	if pane := os.Getenv("TMUX_PANE"); pane != "" {
+		args = append(args, "-t", pane)
+	}
+	if out, err := exec.Command("tmux", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("tmux paste-buffer failed: %s %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
@@ -0,0 +1,268 @@
+//go:build linux
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	// Imported for its init, before Bubble Tea's
+	_ "ffs/lazyterm"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// How many visits are shown in the preview pane
+const tuiPreviewVisits = 10
+
+// Styles of the TUI, set up by runTUI once the terminal is known
+var (
+	tuiSelectedStyle lipgloss.Style
+	tuiDimStyle      lipgloss.Style
+	tuiTitleStyle    lipgloss.Style
+	tuiPreviewStyle  lipgloss.Style
+)
+
+// The details of a result shown in the preview pane
+type tuiPreview struct {
+	visits    []time.Time
+	bookmarks []string
+	err       error
+}
+
+// A full screen result browser with a live filter and a preview pane
+type tuiModel struct {
+	db       *sql.DB
+	results  []*Result
+	matches  []int
+	input    textinput.Model
+	selected int
+	offset   int
+	width    int
+	height   int
+	previews map[string]*tuiPreview
+	picked   *Result
+}
+
+// Runs the TUI over results, returns the result picked with Enter
+// or errPickCanceled if the TUI was left without picking one
+func runTUI(db *sql.DB, results []*Result) (*Result, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("the TUI needs a terminal: %s", err)
+	}
+	defer tty.Close()
+
+	// Styles are rendered for the terminal the TUI is drawn on, which is
+	// only queried for its colors if a style needs them, see lazyterm
+	lipgloss.SetDefaultRenderer(lipgloss.NewRenderer(tty))
+	tuiSelectedStyle = lipgloss.NewStyle().Reverse(true)
+	tuiDimStyle = lipgloss.NewStyle().Faint(true)
+	tuiTitleStyle = lipgloss.NewStyle().Bold(true)
+	tuiPreviewStyle = lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder(), false, false, false, true).
+		PaddingLeft(1)
+
+	input := textinput.New()
+	input.Prompt = "ffs> "
+	input.Focus()
+
+	m := &tuiModel{
+		db:       db,
+		results:  results,
+		input:    input,
+		previews: make(map[string]*tuiPreview),
+	}
+	m.filter()
+
+	// Draw on the terminal so stdout can be piped
+	p := tea.NewProgram(m, tea.WithAltScreen(), tea.WithInput(tty), tea.WithOutput(tty))
+	if _, err := p.Run(); err != nil {
+		return nil, err
+	}
+
+	if m.picked == nil {
+		return nil, errPickCanceled
+	}
+
+	return m.picked, nil
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.move(0)
+		return m, nil
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c", "esc":
+			return m, tea.Quit
+		case "enter":
+			if len(m.matches) > 0 {
+				m.picked = m.results[m.matches[m.selected]]
+			}
+			return m, tea.Quit
+		case "up", "ctrl+p", "ctrl+k":
+			m.move(-1)
+			return m, nil
+		case "down", "ctrl+n", "ctrl+j":
+			m.move(1)
+			return m, nil
+		case "pgup":
+			m.move(-m.listHeight())
+			return m, nil
+		case "pgdown":
+			m.move(m.listHeight())
+			return m, nil
+		}
+	}
+
+	before := m.input.Value()
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	if m.input.Value() != before {
+		m.filter()
+	}
+
+	return m, cmd
+}
+
+func (m *tuiModel) View() string {
+	if m.width == 0 {
+		return ""
+	}
+
+	listWidth := m.width
+	previewWidth := 0
+	if m.width >= 80 {
+		previewWidth = m.width * 2 / 5
+		listWidth = m.width - previewWidth
+	}
+
+	var list strings.Builder
+	end := min(m.offset+m.listHeight(), len(m.matches))
+	for i := m.offset; i < end; i++ {
+		line := truncate(pickerLine(m.results[m.matches[i]]), max(listWidth-2, 1))
+		if i == m.selected {
+			list.WriteString(tuiSelectedStyle.Render("> "+line) + "\n")
+		} else {
+			list.WriteString("  " + line + "\n")
+		}
+	}
+
+	body := lipgloss.NewStyle().Width(listWidth).Height(m.listHeight()).Render(strings.TrimSuffix(list.String(), "\n"))
+	if previewWidth > 0 {
+		preview := tuiPreviewStyle.Width(previewWidth - 2).Height(m.listHeight()).Render(m.previewView(previewWidth - 3))
+		body = lipgloss.JoinHorizontal(lipgloss.Top, body, preview)
+	}
+
+	status := tuiDimStyle.Render(fmt.Sprintf("  %d/%d  enter: pick  esc: quit", len(m.matches), len(m.results)))
+	return m.input.View() + "\n" + status + "\n" + body
+}
+
+// Renders the preview of the selected result
+func (m *tuiModel) previewView(width int) string {
+	if len(m.matches) == 0 {
+		return ""
+	}
+	r := m.results[m.matches[m.selected]]
+	wrap := lipgloss.NewStyle().Width(width)
+
+	var sb strings.Builder
+	title := sanitizeText(r.Title)
+	if title == "" {
+		title = "(no title)"
+	}
+	sb.WriteString(wrap.Inherit(tuiTitleStyle).Render(title) + "\n")
+	sb.WriteString(wrap.Render(sanitizeText(r.URL)) + "\n\n")
+	if r.Description != "" {
+		sb.WriteString(wrap.Render(sanitizeText(r.Description)) + "\n\n")
+	}
+
+	p := m.preview(r)
+	if p.err != nil {
+		sb.WriteString(wrap.Render("error: "+p.err.Error()) + "\n")
+		return sb.String()
+	}
+
+	if len(p.bookmarks) > 0 {
+		sb.WriteString(wrap.Render("★ bookmarked as "+strconv.Quote(p.bookmarks[0])) + "\n\n")
+	} else {
+		sb.WriteString(tuiDimStyle.Render("not bookmarked") + "\n\n")
+	}
+
+	fmt.Fprintf(&sb, "%d %s, frecency %d\n", r.VisitCount, plural(r.VisitCount, "visit", "visits"), r.Frecency)
+	for _, visit := range p.visits {
+		sb.WriteString(tuiDimStyle.Render("  "+visit.Local().Format("2006-01-02 15:04")+"  "+relativeTime(visit, time.Now())) + "\n")
+	}
+
+	return sb.String()
+}
+
+// Returns the cached preview details of r, loading them on first use
+func (m *tuiModel) preview(r *Result) *tuiPreview {
+	if p, ok := m.previews[r.URL]; ok {
+		return p
+	}
+
+	p := &tuiPreview{}
+	p.visits, p.err = recentVisits(m.db, r.URL, tuiPreviewVisits)
+	if p.err == nil {
+		p.bookmarks, p.err = bookmarkTitles(m.db, r.URL)
+	}
+	m.previews[r.URL] = p
+
+	return p
+}
+
+// Updates the matches for the current filter input
+func (m *tuiModel) filter() {
+	terms := strings.Fields(strings.ToLower(m.input.Value()))
+
+	m.matches = m.matches[:0]
+	for i, r := range m.results {
+		line := strings.ToLower(pickerLine(r))
+		ok := true
+		for _, term := range terms {
+			if !fuzzyMatch(line, term) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			m.matches = append(m.matches, i)
+		}
+	}
+
+	m.selected, m.offset = 0, 0
+}
+
+// Moves the selection by delta, scrolling if needed
+func (m *tuiModel) move(delta int) {
+	if len(m.matches) == 0 {
+		return
+	}
+
+	m.selected = min(max(m.selected+delta, 0), len(m.matches)-1)
+	if m.selected < m.offset {
+		m.offset = m.selected
+	}
+	if m.selected >= m.offset+m.listHeight() {
+		m.offset = m.selected - m.listHeight() + 1
+	}
+}
+
+// Returns the number of lines available for the result list
+func (m *tuiModel) listHeight() int {
+	return max(m.height-2, 1)
+}
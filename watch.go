@@ -0,0 +1,267 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	// How long to wait for Firefox to finish writing before re-reading
+	watchSettle = time.Second
+	// The inotify events on the profile directory that mean a new write
+	watchEvents = syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_MOVED_TO
+)
+
+// The flags of the watch subcommand
+var watchFlags struct {
+	interval string
+	notify   bool
+	webhook  string
+}
+
+// Returns the flag set of the watch subcommand
+func watchFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	profileFlag(fs)
+	fs.StringVar(&watchFlags.interval, "interval", "1m", "re-read the history at least this often, e.g. `30s`")
+	fs.BoolVar(&watchFlags.notify, "notify", false, "show a desktop notification for every new visit, using notify-send")
+	fs.StringVar(&watchFlags.webhook, "webhook", "", "POST every new visit as JSON to `url`")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: ffs watch [flags] \"<query>\"\n\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Runs the watch subcommand, printing new visits matching the query as
+// Firefox records them until interrupted
+func runWatch(args []string) {
+	positional, err := parseArgs(watchFlagSet(), args)
+	if err != nil {
+		os.Exit(exitError)
+	}
+
+	query := "*"
+	if len(positional) > 0 {
+		query = strings.Join(positional, " ")
+	}
+	if err := validateGlob(query); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitError)
+	}
+
+	every, err := parseDuration(watchFlags.interval)
+	if err != nil || every <= 0 {
+		fmt.Fprintf(os.Stderr, "invalid interval %q\n", watchFlags.interval)
+		os.Exit(exitError)
+	}
+
+	// Called for every new visit besides printing it
+	var hooks []func(query string, res *Result) error
+	if watchFlags.notify {
+		if _, err := exec.LookPath("notify-send"); err != nil {
+			fmt.Fprintf(os.Stderr, "--notify needs notify-send (libnotify): %s\n", err)
+			os.Exit(exitError)
+		}
+		hooks = append(hooks, notifyVisit)
+	}
+	if watchFlags.webhook != "" {
+		u, err := url.Parse(watchFlags.webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fmt.Fprintf(os.Stderr, "invalid webhook URL %q\n", watchFlags.webhook)
+			os.Exit(exitError)
+		}
+		hooks = append(hooks, func(query string, res *Result) error {
+			return postVisit(watchFlags.webhook, query, res)
+		})
+	}
+
+	profileDir, err := getFirefoxProfileDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get Mozilla profile directory: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	changes, err := watchPlaces(profileDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to watch %s: %s\n", profileDir, err)
+		os.Exit(exitError)
+	}
+
+	// Stops watching, also while a snapshot is taken
+	ctx, stop := signalContext()
+	defer stop()
+
+	// Only visits after the start are printed
+	db, cleanup, err := openPlaces(ctx, profileDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", contextError(ctx, err))
+		os.Exit(exitCode(exitError))
+	}
+	last, err := lastVisitID(db)
+	cleanup()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitError)
+	}
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	settle := time.NewTimer(watchSettle)
+	settle.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			os.Exit(exitCode(exitError))
+		case <-changes:
+			// Firefox writes in bursts, read once it is done
+			settle.Reset(watchSettle)
+			continue
+		case <-settle.C:
+		case <-ticker.C:
+		}
+
+		last, err = printNewVisits(ctx, profileDir, query, last, hooks)
+		if ctx.Err() != nil {
+			os.Exit(exitCode(exitError))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+	}
+}
+
+// Prints the visits matching query after the visit id last from a new
+// snapshot of the history, calls the hooks for each and returns the id of
+// the latest visit. Failing hooks are reported, but do not stop watching.
+func printNewVisits(ctx context.Context, profileDir, query string, last int64, hooks []func(query string, res *Result) error) (int64, error) {
+	db, cleanup, err := openPlaces(ctx, profileDir)
+	if err != nil {
+		return last, err
+	}
+	defer cleanup()
+
+	err = newVisits(db, query, last, func(res *Result) error {
+		for _, hook := range hooks {
+			if err := hook(query, res); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+			}
+		}
+
+		_, err := fmt.Printf("%s\t%s\t%s\n", res.LastVisit.Format(time.RFC3339), sanitizeText(tsvEscaper.Replace(res.URL)), sanitizeText(tsvEscaper.Replace(res.Title)))
+		return err
+	})
+	if err != nil {
+		return last, err
+	}
+
+	return lastVisitID(db)
+}
+
+// Shows a desktop notification about a visit, with the title as summary
+func notifyVisit(query string, res *Result) error {
+	summary := res.Title
+	if summary == "" {
+		summary = res.URL
+	}
+
+	// notify-send interprets markup in the body
+	body := html.EscapeString(res.URL)
+
+	if err := exec.Command("notify-send", "--app-name=ffs", "--icon=firefox", "--", summary, body).Run(); err != nil {
+		return fmt.Errorf("failed to show notification: %s", err)
+	}
+
+	return nil
+}
+
+// The JSON body posted to --webhook
+type webhookPayload struct {
+	// The query of ffs watch
+	Query string `json:"query"`
+	// The page, last_visit is the time of the new visit
+	Visit jsonResult `json:"visit"`
+}
+
+// Used for --webhook, so a hanging endpoint does not stall watching
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// POSTs a visit to the webhook at target
+func postVisit(target, query string, res *Result) error {
+	body, err := json.Marshal(webhookPayload{Query: query, Visit: newJSONResult(res)})
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %s", err)
+	}
+
+	resp, err := webhookClient.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook failed: %s", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook failed: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Watches profileDir with inotify and sends on the returned channel
+// whenever places.sqlite or one of its sidecar files is written
+func watchPlaces(profileDir string) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := syscall.InotifyAddWatch(fd, profileDir, watchEvents); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := syscall.Read(fd, buf)
+			if err != nil {
+				if err == syscall.EINTR {
+					continue
+				}
+				return
+			}
+
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(event.Len)]
+				off += syscall.SizeofInotifyEvent + int(event.Len)
+
+				if bytes.HasPrefix(name, []byte("places.sqlite")) {
+					select {
+					case changes <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	return changes, nil
+}
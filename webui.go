@@ -0,0 +1,25 @@
+//go:build linux
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// The single-page history explorer served by ffs serve
+//
+//go:embed web
+var webFiles embed.FS
+
+// Returns the handler serving the web UI
+func webUI() http.Handler {
+	root, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		// The directory is embedded at build time
+		panic(err)
+	}
+
+	return http.FileServerFS(root)
+}
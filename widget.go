@@ -0,0 +1,61 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Shell code binding Ctrl-O to pick a URL with ffs -i and insert it at the
+// cursor, by shell
+var widgets = map[string]string{
+	"bash": `__ffs_widget() {
+  local url
+  url=$(ffs -i) || return
+  url=$(printf '%q' "$url")
+  READLINE_LINE="${READLINE_LINE:0:READLINE_POINT}${url}${READLINE_LINE:READLINE_POINT}"
+  READLINE_POINT=$((READLINE_POINT + ${#url}))
+}
+bind -m emacs-standard -x '"\C-o": __ffs_widget'
+bind -m vi-insert -x '"\C-o": __ffs_widget'
+`,
+	"zsh": `ffs-widget() {
+  local url
+  url=$(ffs -i </dev/tty)
+  if [[ $? -eq 0 && -n $url ]]; then
+    LBUFFER+=${(q)url}
+  fi
+  zle reset-prompt
+}
+zle -N ffs-widget
+bindkey -M emacs '^O' ffs-widget
+bindkey -M viins '^O' ffs-widget
+`,
+	"fish": `function __ffs_widget
+    set -l url (ffs -i)
+    and commandline --insert -- (string escape -- $url)
+    commandline --function repaint
+end
+bind \co __ffs_widget
+bind -M insert \co __ffs_widget 2>/dev/null
+`,
+}
+
+// Runs the widget subcommand, printing the key binding code for a shell
+func runWidget(args []string) {
+	shells := []string{"bash", "fish", "zsh"}
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: ffs widget %s\n", strings.Join(shells, "|"))
+		os.Exit(exitError)
+	}
+
+	code, ok := widgets[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown shell %q (available: %s)\n", args[0], strings.Join(shells, ", "))
+		os.Exit(exitError)
+	}
+
+	fmt.Print(code)
+}